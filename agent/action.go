@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ActionFormat selects the protocol the model uses to choose the next function.
+type ActionFormat int
+
+const (
+	// ActionFormatText asks for "Function:", "Input:", "Reasoning:" and "Critism:" lines.
+	ActionFormatText ActionFormat = iota
+	// ActionFormatJSON asks for a JSON object validated against the registered functions.
+	ActionFormatJSON
+)
+
+// WithActionFormat sets the protocol the model uses to choose the next function.
+func WithActionFormat(format ActionFormat) AgentOption {
+	return func(a *Agent) {
+		a.ActionFormat = format
+	}
+}
+
+// jsonActionFormat describes the JSON action object to the model.
+const jsonActionFormat = "{\n" +
+	"  \"function\": \"name of the function\",\n" +
+	"  \"arguments\": {\"input\": \"function input as text, may span multiple lines\"},\n" +
+	"  \"reasoning\": \"reason to choose the function and input\",\n" +
+	"  \"criticism\": \"self critic of the current action\"\n" +
+	"}\n"
+
+// responseFormat returns the backend response format for action requests.
+func (a *Agent) responseFormat() string {
+	if a.ActionFormat == ActionFormatJSON {
+		return "json"
+	}
+	return ""
+}
+
+// Action is a single function call chosen by the model.
+type Action struct {
+	Function  string          `json:"function"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Reasoning string          `json:"reasoning,omitempty"`
+	Criticism string          `json:"criticism,omitempty"`
+	Input     string          `json:"-"` // Arguments as plain text passed to the function
+}
+
+// parseAction parses model output according to the agent's action format.
+func (a *Agent) parseAction(text string) (Action, error) {
+	if a.ActionFormat == ActionFormatJSON {
+		return parseJSONAction(text, a.Tools)
+	}
+	action, err := parseOutput(text)
+	action.Arguments = argumentsFromInput(action.Input)
+	return action, err
+}
+
+// actionFields are the line prefixes of the text action format.
+var actionFields = []string{"Function", "Input", "Reasoning", "Critism", "Criticism"}
+
+// cutField splits a line such as "Input: text" into its field name and value.
+func cutField(line string) (field, value string, found bool) {
+	for _, name := range actionFields {
+		if strings.HasPrefix(line, name+":") {
+			return name, strings.TrimSpace(strings.TrimPrefix(line, name+":")), true
+		}
+	}
+	return "", "", false
+}
+
+// parseOutput parses the text action format. Every field may span several
+// lines; parsing stops at the start of a second function.
+func parseOutput(text string) (Action, error) {
+	var action Action
+	var current string
+	var input []string
+lines:
+	for _, line := range strings.Split(text, "\n") {
+		field, value, found := cutField(strings.TrimSpace(line))
+		if !found {
+			switch current {
+			case "Input":
+				input = append(input, strings.TrimRight(line, " \t\r"))
+			case "Reasoning":
+				action.Reasoning += "\n" + strings.TrimSpace(line)
+			case "Critism", "Criticism":
+				action.Criticism += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		if field == "Function" && action.Function != "" {
+			break lines // Stop after finding the first complete function
+		}
+		current = field
+		switch field {
+		case "Function":
+			action.Function = value
+		case "Input":
+			input = []string{value}
+		case "Reasoning":
+			action.Reasoning = value
+		case "Critism", "Criticism":
+			action.Criticism = value
+		}
+	}
+	action.Input = strings.Trim(strings.TrimSpace(strings.Join(input, "\n")), "\"")
+	action.Reasoning = strings.TrimSpace(action.Reasoning)
+	action.Criticism = strings.TrimSpace(action.Criticism)
+	if action.Function == "" {
+		return action, fmt.Errorf("missing required field Function")
+	}
+	return action, nil
+}
+
+// parseJSONAction parses the JSON action format and validates it against tools.
+// The object may be surrounded by other text such as a markdown code fence.
+func parseJSONAction(text string, tools *ToolRegistry) (Action, error) {
+	var action Action
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return action, fmt.Errorf("no JSON object found")
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &action); err != nil {
+		return action, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if action.Function == "" {
+		return action, fmt.Errorf("missing required field \"function\"")
+	}
+	// Unknown functions are left to the agent's UnknownToolPolicy.
+	if tool, found := findFunctionByName(tools, action.Function); found {
+		if err := validateArguments(tool, action.Arguments); err != nil {
+			return action, err
+		}
+	}
+	input, err := argumentsAsInput(action.Arguments)
+	if err != nil {
+		return action, err
+	}
+	action.Input = input
+	return action, nil
+}
+
+// argumentsAsInput converts JSON arguments into the plain text input of a function.
+// Arguments may be a string, an object with an "input" field, or omitted.
+// Other objects are returned as JSON text.
+func argumentsAsInput(arguments json.RawMessage) (string, error) {
+	if len(arguments) == 0 || string(arguments) == "null" {
+		return "", nil
+	}
+	var input string
+	if err := json.Unmarshal(arguments, &input); err == nil {
+		return input, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(arguments, &object); err != nil {
+		return "", fmt.Errorf("\"arguments\" must be a string or an object")
+	}
+	value, ok := object["input"]
+	if !ok {
+		return string(arguments), nil
+	}
+	if err := json.Unmarshal(value, &input); err != nil {
+		return string(value), nil
+	}
+	return input, nil
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Action
+	}{
+		{
+			name: "single line fields",
+			text: "Function: Search\nInput: gophers\nReasoning: need facts\nCritism: none",
+			want: Action{Function: "Search", Input: "gophers", Reasoning: "need facts", Criticism: "none"},
+		},
+		{
+			name: "multi-line input",
+			text: "Function: Finish\nInput: line one\n  line two\nReasoning: done",
+			want: Action{Function: "Finish", Input: "line one\n  line two", Reasoning: "done"},
+		},
+		{
+			name: "quoted input",
+			text: "Function: Search\nInput: \"gophers\"",
+			want: Action{Function: "Search", Input: "gophers"},
+		},
+		{
+			name: "stops at the second function",
+			text: "Function: Search\nInput: one\nFunction: Browse\nInput: two",
+			want: Action{Function: "Search", Input: "one"},
+		},
+		{
+			name: "leading text and criticism spelling",
+			text: "Sure, here you go.\n  Function: Search\n  Input: x\n  Criticism: too broad\n  narrow it",
+			want: Action{Function: "Search", Input: "x", Criticism: "too broad\nnarrow it"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOutput(tt.text)
+			if err != nil {
+				t.Fatalf("parseOutput: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOutput = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOutputRequiresFunction(t *testing.T) {
+	if _, err := parseOutput("Input: gophers\nReasoning: none"); err == nil {
+		t.Fatal("expected an error without a Function line")
+	}
+}
+
+func TestParseJSONAction(t *testing.T) {
+	tools, err := NewToolRegistry(NewTool("Search", "Searches", "query", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		text      string
+		wantInput string
+		wantErr   string
+	}{
+		{name: "object arguments", text: `{"function":"Search","arguments":{"input":"gophers"}}`, wantInput: "gophers"},
+		{name: "string arguments", text: `{"function":"Search","arguments":"gophers"}`, wantInput: "gophers"},
+		{name: "code fence", text: "```json\n{\"function\":\"Search\",\"arguments\":{\"input\":\"a}b\"}}\n```", wantInput: "a}b"},
+		{name: "unknown function is left to the policy", text: `{"function":"Lookup","arguments":{"q":"x"}}`, wantInput: `{"q":"x"}`},
+		{name: "no object", text: "Function: Search", wantErr: "no JSON object"},
+		{name: "invalid JSON", text: `{"function": Search}`, wantErr: "invalid JSON"},
+		{name: "missing function", text: `{"arguments":{"input":"x"}}`, wantErr: `"function"`},
+		{name: "missing required argument", text: `{"function":"Search","arguments":{}}`, wantErr: "input"},
+		{name: "wrong argument type", text: `{"function":"Search","arguments":{"input":3}}`, wantErr: "type string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := parseJSONAction(tt.text, tools)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseJSONAction: %v", err)
+			}
+			if action.Input != tt.wantInput {
+				t.Errorf("input = %q, want %q", action.Input, tt.wantInput)
+			}
+		})
+	}
+}
+
+func TestJSONActionFormatRunsTools(t *testing.T) {
+	llm := &fakeLLM{replies: []string{`{"function":"Finish","arguments":{"input":"done"},"reasoning":"finished"}`}}
+	a := newTestAgent(llm, WithActionFormat(ActionFormatJSON))
+
+	if got, err := a.Run("hello"); err != nil || got != "done" {
+		t.Fatalf("Run = %q, %v, want done", got, err)
+	}
+	if format := llm.stageRequests(StageAction)[0].Format; format != "json" {
+		t.Errorf("request format = %q, want json", format)
+	}
+}
+
+func TestArgumentsAsInput(t *testing.T) {
+	tests := map[string]string{
+		``:                          "",
+		`null`:                      "",
+		`"text"`:                    "text",
+		`{"input":"text"}`:          "text",
+		`{"input":{"nested":true}}`: `{"nested":true}`,
+		`{"url":"x","depth":2}`:     `{"url":"x","depth":2}`,
+	}
+	for args, want := range tests {
+		got, err := argumentsAsInput(json.RawMessage(args))
+		if err != nil || got != want {
+			t.Errorf("argumentsAsInput(%s) = %q, %v, want %q", args, got, err, want)
+		}
+	}
+	if _, err := argumentsAsInput(json.RawMessage(`[1]`)); err == nil {
+		t.Error("argumentsAsInput accepted an array")
+	}
+}
@@ -1,11 +1,15 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bgokden/miniagent/messages"
 	"github.com/bgokden/miniagent/prompt"
+	"github.com/bgokden/miniagent/renderer"
 )
 
 type FunctionInfo struct {
@@ -13,13 +17,53 @@ type FunctionInfo struct {
 	FunctionDescription string
 	FunctionInput       string
 	FunctionRef         func(string) string
+	FunctionRefContext  func(context.Context, string) string // Preferred over FunctionRef when set
+}
+
+// call invokes the function, passing ctx when it accepts one.
+func (f FunctionInfo) call(ctx context.Context, input string) string {
+	if f.FunctionRefContext != nil {
+		return f.FunctionRefContext(ctx, input)
+	}
+	return f.FunctionRef(input)
 }
 
 type Agent struct {
-	PromptTree     *prompt.FunctionNode
-	MaxLength      int
-	MessageHistory *messages.MessageHistory
-	Functions      []FunctionInfo
+	PromptTree        *prompt.FunctionNode
+	MaxLength         int
+	MessageHistory    *messages.MessageHistory
+	Tools             *ToolRegistry
+	Browser           *BrowserPool // Renders JavaScript heavy pages for Browse, created on first use
+	LLM               LLM
+	OnToken           func(StreamToken)
+	Options           *GenerateOptions
+	StageOptions      map[Stage]*GenerateOptions
+	Model             string
+	StageModels       map[Stage]string
+	Budget            Budget
+	Repair            RepairOptions
+	Digest            DigestOptions
+	ActionFormat      ActionFormat
+	UnknownTools      UnknownToolPolicy
+	OnEvent           func(Event)
+	TokenCounter      prompt.TokenCounter // Measures prompt parts and page chunks
+	ChatTemplate      renderer.Template   // Renders requests into raw prompts, see WithChatTemplate
+	PromptConcurrency int                 // Prompt tree generators run at the same time, see prompt.WithConcurrency
+
+	// Deprecated: Use WithFunctions or RegisterTool. Functions are registered
+	// as tools, replacing tools of the same name, when a run starts.
+	Functions []FunctionInfo
+
+	configErr error // First error raised by an AgentOption, returned by Run
+
+	streamMu       sync.Mutex // Serializes OnToken calls
+	streamRequests int        // Streamed requests so far, numbers StreamToken.Request
+	browserMu      sync.Mutex // Guards the lazy creation of Browser
+
+	usageMu  sync.Mutex
+	usage    Usage
+	events   []Event
+	runStart time.Time
 }
 
 type AgentOption func(*Agent)
@@ -36,33 +80,120 @@ func WithMaxLength(maxLength int) AgentOption {
 	}
 }
 
+// WithTokenCounter sets the counter used for prompt budgeting and page
+// chunking, e.g. prompt.ModelTokenCounter(model) or a tokenizer.json loaded
+// with prompt.NewFileTokenCounter on air-gapped machines.
+func WithTokenCounter(counter prompt.TokenCounter) AgentOption {
+	return func(a *Agent) {
+		a.TokenCounter = counter
+	}
+}
+
+// WithPromptConcurrency generates up to n nodes of the prompt tree at the
+// same time. The generators of the tree must be safe for concurrent use.
+func WithPromptConcurrency(n int) AgentOption {
+	return func(a *Agent) {
+		a.PromptConcurrency = n
+	}
+}
+
+// tokenCounter returns the configured counter or one for the model of the
+// action stage, which writes the prompts being budgeted.
+func (a *Agent) tokenCounter() prompt.TokenCounter {
+	if a.TokenCounter != nil {
+		return a.TokenCounter
+	}
+	model := a.modelFor(StageAction)
+	if model == "" {
+		model = MODEL_NAME
+	}
+	return prompt.ModelTokenCounter(model)
+}
+
+// WithLLM sets the model backend used for every generation step.
+func WithLLM(llm LLM) AgentOption {
+	return func(a *Agent) {
+		a.LLM = llm
+	}
+}
+
+// StreamToken is a partial token streamed while the model generates.
+type StreamToken struct {
+	Stage   Stage  // Agent step the request belongs to
+	Request int    // Numbers the streamed requests of the agent from 1
+	Chunk   int    // Page chunk summarized by the request, counted from 1, 0 for other requests
+	Text    string // Partial token
+}
+
+// WithStreamHandler sets a callback that receives partial tokens while the model generates.
+// Tokens of every stage are streamed, including intent inference and page summaries.
+// The handler is never called concurrently, but page summaries are generated
+// in parallel, so their tokens arrive interleaved; Request and Chunk tell
+// them apart.
+func WithStreamHandler(onToken func(StreamToken)) AgentOption {
+	return func(a *Agent) {
+		a.OnToken = onToken
+	}
+}
+
+// WithOptions sets the generation options used by every stage.
+func WithOptions(opts *GenerateOptions) AgentOption {
+	return func(a *Agent) {
+		a.Options = opts
+	}
+}
+
+// WithStageOptions overrides generation options for a single stage.
+// Fields left nil fall back to the options set by WithOptions.
+func WithStageOptions(stage Stage, opts *GenerateOptions) AgentOption {
+	return func(a *Agent) {
+		if a.StageOptions == nil {
+			a.StageOptions = make(map[Stage]*GenerateOptions)
+		}
+		a.StageOptions[stage] = opts
+	}
+}
+
+// WithModel sets the model used by every stage. An empty name keeps the backend's default.
+func WithModel(model string) AgentOption {
+	return func(a *Agent) {
+		a.Model = model
+	}
+}
+
+// WithStageModel routes a single stage to a different model,
+// e.g. a small model for intent inference and page summaries.
+func WithStageModel(stage Stage, model string) AgentOption {
+	return func(a *Agent) {
+		if a.StageModels == nil {
+			a.StageModels = make(map[Stage]string)
+		}
+		a.StageModels[stage] = model
+	}
+}
+
 func NewAgent(options ...AgentOption) *Agent {
 	agent := &Agent{
 		MaxLength:      8000, // Default MaxLength
-		PromptTree:     DefaultBuildTree(),
 		MessageHistory: messages.NewMessageHistory(),
-		Functions: []FunctionInfo{
-			{"Search", "This search is useful to get reliable quick data.", "Text to be searched", Search},
-			{"Browse", "This browse is useful when users want to get content of a page.", "website url", Browse},
-			{"CurrentTime", "This function is useful when you need the current time", "N/A", CurrentTime},
-			{"Finish", "This is useful when the agent decides to finish this task and generate output.", "The output that you want to print as the result of your task as detailed as possible.", Finish},
-		},
+		LLM:            NewOllama("", ""),
+		Repair:         DefaultRepairOptions(),
 	}
+	agent.Tools, agent.configErr = NewToolRegistry(DefaultTools()...)
+	agent.Tools.Set(NewBrowseTool(&FallbackFetcher{
+		Primary:  &HTTPFetcher{},
+		Fallback: FetcherFunc(agent.renderPage),
+	}))
 	for _, option := range options {
 		option(agent)
 	}
-	agent.PromptTree = BuildTree(agent)
-	return agent
-}
-
-func findFunctionByName(functions []FunctionInfo, name string) (FunctionInfo, bool) {
-	lowerName := strings.ToLower(name)
-	for _, fn := range functions {
-		if strings.ToLower(fn.FunctionName) == lowerName {
-			return fn, true
-		}
+	if agent.ChatTemplate != nil && !supportsRaw(agent.LLM) && agent.configErr == nil {
+		agent.configErr = fmt.Errorf("chat template %s needs raw prompts, which the LLM backend does not support", agent.ChatTemplate.Name())
+	}
+	if agent.PromptTree == nil {
+		agent.PromptTree = BuildTree(agent)
 	}
-	return FunctionInfo{}, false
+	return agent
 }
 
 func (a *Agent) GeneratePrompt(input ...string) (string, string, error) {
@@ -72,15 +203,187 @@ func (a *Agent) GeneratePrompt(input ...string) (string, string, error) {
 	} else {
 		userInput = "default input"
 	}
+	return a.generatePrompt(context.Background(), userInput)
+}
 
+// generatePrompt is GeneratePrompt for a run; parts summarized to fit are
+// summarized with ctx.
+func (a *Agent) generatePrompt(ctx context.Context, userInput string) (string, string, error) {
 	// Call the external GeneratePrompt function with the agent's PromptTree, userInput, and MaxLength
-	system, err := prompt.GeneratePrompt(a.PromptTree, userInput, a.MaxLength)
+	system, err := prompt.GeneratePrompt(a.PromptTree, userInput, a.MaxLength, a.promptOptions(ctx)...)
 	if err != nil {
 		return "", "", err
 	}
 	return system, userInput, err
 }
 
+// ExplainPrompt generates the prompt for input like GeneratePrompt and
+// reports what each node of the prompt tree contributed.
+func (a *Agent) ExplainPrompt(input string) (*prompt.Report, error) {
+	if a.configErr != nil {
+		return nil, a.configErr
+	}
+	a.registerFunctions()
+	return prompt.GeneratePromptWithReport(a.PromptTree, input, a.MaxLength, a.promptOptions(context.Background())...)
+}
+
+// promptOptions configures prompt generation for the agent's prompt tree.
+func (a *Agent) promptOptions(ctx context.Context) []prompt.Option {
+	return []prompt.Option{
+		prompt.WithTokenCounter(a.tokenCounter()),
+		prompt.WithSummarizer(a.summarizer(ctx)),
+		prompt.WithGenerators(a.Generators()),
+		prompt.WithTemplateData(a.promptData()),
+		prompt.WithConcurrency(a.PromptConcurrency),
+	}
+}
+
+// newRequest builds a request for the agent loop. Backends that take
+// role-separated messages get the newest messages that fit in MaxLength
+// next to system, and the markup of the chat template if set, followed by
+// prompt as the last user turn. Prompt is left
+// out when it is the last human message and the whole conversation fits, so
+// the task is not sent twice.
+func (a *Agent) newRequest(system, userPrompt string) (LLMRequest, error) {
+	req := LLMRequest{System: system, Prompt: userPrompt, Format: a.responseFormat()}
+	if !a.sendsMessages() {
+		return req, nil
+	}
+	counter := a.tokenCounter()
+	msgs := a.MessageHistory.GetMessages()
+	used, err := counter.CountTokens(system)
+	if err != nil {
+		return req, err
+	}
+	count, perMessage := counter.CountTokens, 0
+	if a.ChatTemplate != nil {
+		// Raw requests carry the markup of the chat template, so room is kept for it.
+		var fixed int
+		if fixed, perMessage, err = renderer.Markup(a.ChatTemplate, system, userPrompt, counter); err != nil {
+			return req, err
+		}
+		used += fixed
+		count = func(s string) (int, error) {
+			n, err := counter.CountTokens(s)
+			return n + perMessage, err
+		}
+	}
+	kept, err := messages.Recent(msgs, a.MaxLength-used, count)
+	if err != nil {
+		return req, err
+	}
+	if last, ok := messages.LastHumanContent(msgs); !ok || last != userPrompt || len(kept) < len(msgs) {
+		n, err := counter.CountTokens(userPrompt)
+		if err != nil {
+			return req, err
+		}
+		used += n
+		if kept, err = messages.Recent(msgs, a.MaxLength-used, count); err != nil {
+			return req, err
+		}
+	} else {
+		req.Prompt = ""
+	}
+	if len(kept) == 1 {
+		// The newest message is kept even when it alone is too long, so cut
+		// its content down to what is left.
+		if kept[0], err = a.fitMessage(kept[0], a.MaxLength-used-perMessage); err != nil {
+			return req, err
+		}
+		if kept[0].Content == "" {
+			kept = nil
+		}
+	}
+	req.Messages = kept
+	return req, nil
+}
+
+// modelFor returns the model configured for stage, empty for the backend's default.
+func (a *Agent) modelFor(stage Stage) string {
+	if model, ok := a.StageModels[stage]; ok && model != "" {
+		return model
+	}
+	return a.Model
+}
+
+// Models returns every distinct model the stages of the agent resolve to.
+// An empty name stands for the backend's default model.
+func (a *Agent) Models() []string {
+	var models []string
+	seen := map[string]bool{}
+	for _, stage := range Stages {
+		model := a.modelFor(stage)
+		if !seen[model] {
+			seen[model] = true
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// PullModel pulls every model the agent is configured to use.
+// It is a no-op for backends that do not implement ModelPuller.
+func (a *Agent) PullModel() error {
+	return a.PullModelContext(context.Background())
+}
+
+// PullModelContext is like PullModel but stops when ctx is done.
+func (a *Agent) PullModelContext(ctx context.Context) error {
+	puller, ok := a.LLM.(ModelPuller)
+	if !ok {
+		return nil
+	}
+	for _, model := range a.Models() {
+		if err := puller.PullModel(ctx, model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generate sends req to the agent's LLM for the given stage.
+// Agent, stage and request options are merged in that order and tokens are
+// streamed to the agent's handler if set. It fails without calling the model
+// once a token budget has run out.
+func (a *Agent) generate(ctx context.Context, stage Stage, req LLMRequest) (*GenerateResponse, error) {
+	if err := a.checkTokenBudget(); err != nil {
+		return nil, err
+	}
+	req.Stage = stage
+	req.Model = a.modelFor(stage)
+	req.Options = a.Options.Merge(a.StageOptions[stage]).Merge(req.Options)
+	if req.OnToken == nil {
+		req.OnToken = a.streamTo(stage, 0)
+	}
+	if a.ChatTemplate != nil {
+		req = a.rawRequest(req)
+	}
+	resp, err := a.LLM.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	a.addTokens(resp)
+	return resp, nil
+}
+
+// streamTo returns the backend callback of a new request of stage, which
+// passes its tokens to the agent's stream handler one call at a time. It
+// returns nil without a handler, so the request is not streamed.
+func (a *Agent) streamTo(stage Stage, chunk int) func(string) {
+	if a.OnToken == nil {
+		return nil
+	}
+	a.streamMu.Lock()
+	a.streamRequests++
+	request := a.streamRequests
+	a.streamMu.Unlock()
+	return func(text string) {
+		a.streamMu.Lock()
+		defer a.streamMu.Unlock()
+		a.OnToken(StreamToken{Stage: stage, Request: request, Chunk: chunk, Text: text})
+	}
+}
+
 // DefaultBuildTree builds the default tree of FunctionNodes
 func DefaultBuildTree() *prompt.FunctionNode {
 	systemDescription := func(input string, maxLength int) (string, error) {
@@ -112,64 +415,109 @@ func DefaultBuildTree() *prompt.FunctionNode {
 	return root
 }
 
+// FunctionsAsString renders functions for the prompt.
+//
+// Deprecated: Use ToolsAsString.
 func FunctionsAsString(functions []FunctionInfo) string {
-	var result strings.Builder
-	result.WriteString("------\n")
-	result.WriteString("Functions:\n")
-	for _, fn := range functions {
-		result.WriteString(fmt.Sprintf("- %s:\n", fn.FunctionDescription))
-		result.WriteString(fmt.Sprintf("Function: %s\n", fn.FunctionName))
-		// result.WriteString(fmt.Sprintf("Description: %s\n", fn.FunctionDescription))
-		result.WriteString(fmt.Sprintf("Input: %s\n", fn.FunctionInput))
+	tools := make([]Tool, len(functions))
+	for i, fn := range functions {
+		tools[i] = fn.Tool()
 	}
-	result.WriteString("------\n")
-	return result.String()
+	return ToolsAsString(tools)
 }
 
+// BuildTree builds the prompt tree used by agent unless WithPromptTree or
+// WithPromptTreeFile sets another one.
 func BuildTree(agent *Agent) *prompt.FunctionNode {
-	systemDescription := func(input string, maxLength int) (string, error) {
-		content := "You are an AI Assistant.\n" +
-			"This is a friendly conversation between Human and AI.\n" +
-			"Your primary role is to answer questions and provide assistance.\n" +
-			"Output should only include one function as the next step using the format:\n" +
-			"OUTPUT_FORMAT:\n" +
-			"Function: name of the function\n" +
-			"Input: Function Input as text\n" +
-			"Reasoning: Reason to choose the Function and Input\n" +
-			"Critism: Self critic of the current action\n" +
-			"END_OF_OUTPUT_FORMAT:\n" +
-			"Please only use the given functions in your responses.\n" +
-			"Please write only one function in one response.\n"
-		return content, nil
-	}
-	longTermMemory := func(input string, maxLength int) (string, error) {
-		return "", nil
-	}
-	shortTermMemory := func(input string, maxLength int) (string, error) {
-		return fmt.Sprintf("Conversation:\n%s\n", agent.MessageHistory.GetAllMessagesAsString()), nil
-	}
-	functions := func(input string, maxLength int) (string, error) {
-		content := FunctionsAsString(agent.Functions)
-		return content, nil
-	}
 	// askingDescription := func(input string, maxLength int) (string, error) {
 	// 	return fmt.Sprintf("%s\n", input), nil
 	// }
 
+	// The conversation gives up its oldest messages first and the function
+	// list keeps room for itself however long the conversation gets. The
+	// conversation is generated before the functions but rendered after
+	// them, closest to the model's answer.
+	stm := prompt.NewFunctionNode("stm", 2, agent.historyPart)
+	stm.Truncation = prompt.TruncateStart
+	functionsNode := prompt.NewFunctionNode("functions", 1, agent.toolsPart)
+	functionsNode.MinTokens = 500
+	memories := prompt.NewFunctionNode("memories", 2, nil,
+		prompt.NewFunctionNode("ltm_optional", 1, nil, prompt.NewFunctionNode("ltm", 1, agent.ltmPart)),
+		stm,
+	)
+	memories.Position = 2
+	functionsOptional := prompt.NewFunctionNode("functions_optional", 3, nil, functionsNode)
+	functionsOptional.Position = 1
+
 	root := prompt.NewFunctionNode("root", 0, nil,
-		prompt.NewFunctionNode("system", 1, systemDescription),
-		prompt.NewFunctionNode("memories", 2, nil,
-			prompt.NewFunctionNode("ltm_optional", 1, nil, prompt.NewFunctionNode("ltm", 1, longTermMemory)),
-			prompt.NewFunctionNode("stm", 2, shortTermMemory),
-		),
-		prompt.NewFunctionNode("functions_optional", 3, nil, prompt.NewFunctionNode("functions", 1, functions)),
+		prompt.NewFunctionNode("system", 1, agent.systemPart),
+		memories,
+		functionsOptional,
 		// prompt.NewFunctionNode("asking", 4, askingDescription),
 	)
 
 	return root
 }
 
+// recentHistory renders the newest messages of the conversation that fit in
+// maxTokens, see recentMessages.
+func (a *Agent) recentHistory(maxTokens int) (string, error) {
+	msgs, err := a.recentMessages(a.MessageHistory.GetMessages(), maxTokens)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, msg := range msgs {
+		b.WriteString(msg.String())
+	}
+	return b.String(), nil
+}
+
+// recentMessages returns the newest of msgs that fit in maxTokens, see
+// messages.Recent.
+func (a *Agent) recentMessages(msgs []messages.Message, maxTokens int) ([]messages.Message, error) {
+	return messages.Recent(msgs, maxTokens, a.tokenCounter().CountTokens)
+}
+
+// fitMessage cuts the content of msg so the rendered message fits in
+// maxTokens. The content is empty if not even the sender fits.
+func (a *Agent) fitMessage(msg messages.Message, maxTokens int) (messages.Message, error) {
+	counter := a.tokenCounter()
+	total, err := counter.CountTokens(msg.String())
+	if err != nil || total <= maxTokens {
+		return msg, err
+	}
+	content, err := counter.CountTokens(msg.Content)
+	if err != nil {
+		return msg, err
+	}
+	limit := maxTokens - (total - content)
+	if limit <= 0 {
+		msg.Content = ""
+		return msg, nil
+	}
+	msg.Content, err = prompt.Truncate(msg.Content, limit, prompt.TruncateEnd, prompt.WithTokenCounter(counter))
+	return msg, err
+}
+
+// Run runs the agent loop until the model calls Finish.
 func (a *Agent) Run(input ...string) (string, error) {
+	return a.RunContext(context.Background(), input...)
+}
+
+// RunContext is like Run but every model request, function call and browser
+// session is bound to ctx, so the run can be cancelled or given a deadline.
+// When a budget runs out it returns the partial result with a *BudgetExceededError.
+func (a *Agent) RunContext(parent context.Context, input ...string) (string, error) {
+	if a.configErr != nil {
+		return "", a.configErr
+	}
+	a.registerFunctions()
+
+	ctx, cancel := a.startUsage(parent)
+	defer cancel()
+	defer a.finishUsage()
+
 	var userInput string
 	// Determine the userInput based on the optional input argument
 	if len(input) > 0 {
@@ -180,13 +528,28 @@ func (a *Agent) Run(input ...string) (string, error) {
 
 	a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", userInput)
 
-	userInputInferred := inferPrompt(userInput)
+	userInputInferred := a.inferPrompt(ctx, userInput)
 
 	var functionName, functionInput, lastGoodInput string
 	var generateResp *GenerateResponse
 
 	for functionName != "Finish" {
-		system, prompt, err := a.GeneratePrompt(userInputInferred)
+		if err := a.checkBudget(parent, ctx); err != nil {
+			return lastGoodInput, err
+		}
+		if err := ctx.Err(); err != nil {
+			return lastGoodInput, err
+		}
+
+		system, prompt, err := a.generatePrompt(ctx, userInputInferred)
+		if err != nil {
+			fmt.Println("Error generating prompt:", err)
+			if budgetErr := a.checkBudget(parent, ctx); budgetErr != nil {
+				return lastGoodInput, budgetErr
+			}
+			return "", err
+		}
+		req, err := a.newRequest(system, prompt)
 		if err != nil {
 			fmt.Println("Error generating prompt:", err)
 			return "", err
@@ -194,51 +557,65 @@ func (a *Agent) Run(input ...string) (string, error) {
 
 		// log.Println(prompt)
 
-		generateResp, err = callAPI(system, prompt)
+		generateResp, err = a.generate(ctx, StageAction, req)
 		if err != nil {
 			fmt.Println("Error calling API:", err)
+			if budgetErr := a.checkBudget(parent, ctx); budgetErr != nil {
+				return lastGoodInput, budgetErr
+			}
 			return "", err
 		}
 
-		functionName, functionInput, _, err = parseOutput(generateResp.Response)
+		action, err := a.parseAction(generateResp.Response)
+		if err != nil {
+			action, err = a.repairOutput(ctx, system, prompt, generateResp.Response, err)
+		}
 		if err != nil {
-			// fmt.Println("Error parsing output:", err)
-			// fmt.Println(generateResp.Response)
 			a.MessageHistory.AddMessage(messages.FunctionResult, "System", "", "Error parsing output.")
-			break
-			// continue
+			if budgetErr := a.checkBudget(parent, ctx); budgetErr != nil {
+				return lastGoodInput, budgetErr
+			}
+			return lastGoodInput, err
 		}
+		functionName, functionInput = action.Function, action.Input
 
+		a.addStep()
 		fmt.Printf("Running function: %s\n", functionName)
 
 		// fmt.Printf("Function: %s\nInput: %s\nReasoning: %s\n------\n", functionName, functionInput, reasoning)
+		tool, found := findFunctionByName(a.Tools, functionName)
+		if !found {
+			fmt.Println("Function not found:", functionName)
+			tool, found = a.resolveUnknownTool(action)
+			if !found {
+				a.MessageHistory.AddMessage(messages.FunctionResult, "System", functionName,
+					fmt.Sprintf("Error: Function %s does not exist. Please only use one of these functions: %s.", functionName, strings.Join(a.Tools.Names(), ", ")))
+				continue
+			}
+			functionName = tool.Name()
+		}
+
 		result := ""
 		if len(functionInput) > 0 {
 			lastGoodInput = functionInput
 		}
-		if fn, found := findFunctionByName(a.Functions, functionName); found {
-			result = fn.FunctionRef(functionInput)
-			fmt.Println("Result:", result)
-		} else {
-			fmt.Println("Function not found:", functionName)
-			result = Search(functionInput) // Defauls function call
-		}
-
-		// if functionName == "Search" {
-		// 	result = Search(functionInput)
-		// 	// fmt.Printf("Result:\n%s\n", result)
-		// } else if functionName == "CurrentTime" {
-		// 	result = GetCurrentTimeString()
-		// 	// fmt.Printf("Result:\n%s\n", result)
-		// } else if functionName == "Browse" {
-		// 	result = Browse(functionInput)
-		// 	result = summarizeWebPage(userInput, result)
-		// 	result = fmt.Sprintf("URL: %s \nSummary: %s \n\n", functionInput, result)
-		// 	// fmt.Printf("Result:\n%s\n", result)
-		// }
-		// else if functionName == "Finish" {
-		// 	// fmt.Println(input)
-		// }
+		toolResult, err := tool.Call(ctx, action.Arguments)
+		if err != nil {
+			fmt.Println("Function failed:", err)
+			a.MessageHistory.AddMessage(messages.FunctionResult, "System", functionName, fmt.Sprintf("Error: %v", err))
+			continue
+		}
+		result = toolResult.Content
+		if toolResult.Source != "" && !a.Digest.Disabled {
+			result, err = a.digestPage(ctx, userInput, toolResult.Source, result)
+			if err != nil {
+				fmt.Println("Digesting page failed:", err)
+				a.MessageHistory.AddMessage(messages.FunctionResult, "System", functionName, fmt.Sprintf("Error: %v", err))
+				continue
+			}
+		}
+		fmt.Println("Result:", result)
+
 		if len(result) > 0 {
 			a.MessageHistory.AddMessage(messages.FunctionResult, "System", functionName, result)
 			lastGoodInput = result
@@ -0,0 +1,302 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+	"github.com/bgokden/miniagent/prompt"
+)
+
+// fakeLLM answers action requests with replies in order, intent inference
+// with inferred if set and every other stage with the prompt it was given. It records every request.
+type fakeLLM struct {
+	mu       sync.Mutex
+	replies  []string
+	inferred string // Answer to intent inference requests if set
+	requests []LLMRequest
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	if req.Stage == StageInferPrompt && f.inferred != "" {
+		return &GenerateResponse{Response: f.inferred, Done: true}, nil
+	}
+	if req.Stage != StageAction {
+		return &GenerateResponse{Response: req.Prompt, Done: true}, nil
+	}
+	if len(f.replies) == 0 {
+		return nil, errors.New("fakeLLM: no replies left")
+	}
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	return &GenerateResponse{Response: reply, Done: true, PromptEvalCount: 10, EvalCount: 5}, nil
+}
+
+// stageRequests returns the recorded requests of stage.
+func (f *fakeLLM) stageRequests(stage Stage) []LLMRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []LLMRequest
+	for _, req := range f.requests {
+		if req.Stage == stage {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+// newTestAgent returns an agent that talks to llm, counts tokens without a
+// tokenizer and only has the Finish tool plus tools.
+func newTestAgent(llm LLM, options ...AgentOption) *Agent {
+	defaults := []AgentOption{
+		WithLLM(llm),
+		WithTokenCounter(prompt.HeuristicCounter{}),
+		WithoutTools("Search", "Browse", "CurrentTime"),
+	}
+	return NewAgent(append(defaults, options...)...)
+}
+
+func TestRunCallsToolsUntilFinish(t *testing.T) {
+	llm := &fakeLLM{replies: []string{
+		"Function: Lookup\nInput: gophers\nReasoning: need facts",
+		"Function: Finish\nInput: Gophers dig.",
+	}}
+	var calls []string
+	lookup := NewTool("Lookup", "Looks things up", "query", func(ctx context.Context, input string) (string, error) {
+		calls = append(calls, input)
+		return "Gophers live underground.", nil
+	})
+	a := newTestAgent(llm, WithTools(lookup))
+
+	result, err := a.Run("Tell me about gophers")
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result != "Gophers dig." {
+		t.Errorf("result = %q, want %q", result, "Gophers dig.")
+	}
+	if len(calls) != 1 || calls[0] != "gophers" {
+		t.Errorf("Lookup calls = %q, want [gophers]", calls)
+	}
+	if got := a.Usage().Steps; got != 2 {
+		t.Errorf("steps = %d, want 2", got)
+	}
+
+	actions := llm.stageRequests(StageAction)
+	if len(actions) != 2 {
+		t.Fatalf("action requests = %d, want 2", len(actions))
+	}
+	if !strings.Contains(actions[1].System, "[Lookup] Gophers live underground.") {
+		t.Errorf("second prompt does not contain the function result:\n%s", actions[1].System)
+	}
+	if len(llm.stageRequests(StageInferPrompt)) != 1 {
+		t.Errorf("expected one intent inference request")
+	}
+}
+
+func TestRunRecordsConversation(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"Function: Finish\nInput: done"}}
+	a := newTestAgent(llm)
+
+	if _, err := a.Run("hello"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	msgs := a.MessageHistory.GetMessages()
+	want := []messages.Message{
+		{Type: messages.HumanMessage, Sender: "Human", Content: "hello"},
+		{Type: messages.FunctionResult, Sender: "System", FunctionName: "Finish", Content: "done"},
+		{Type: messages.AIMessage, Sender: "AI", Content: "done"},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("history = %+v, want %+v", msgs, want)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Errorf("message %d = %+v, want %+v", i, msgs[i], want[i])
+		}
+	}
+}
+
+func TestRunReturnsBackendErrors(t *testing.T) {
+	backendErr := errors.New("backend down")
+	llm := LLMFunc(func(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+		if req.Stage == StageInferPrompt {
+			return &GenerateResponse{Response: req.Prompt}, nil
+		}
+		return nil, backendErr
+	})
+	a := newTestAgent(llm)
+
+	if _, err := a.Run("hello"); !errors.Is(err, backendErr) {
+		t.Fatalf("Run error = %v, want %v", err, backendErr)
+	}
+}
+
+func TestRunContinuesWhenIntentInferenceFails(t *testing.T) {
+	llm := LLMFunc(func(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+		if req.Stage == StageInferPrompt {
+			return nil, errors.New("no model")
+		}
+		return &GenerateResponse{Response: "Function: Finish\nInput: ok"}, nil
+	})
+	a := newTestAgent(llm)
+
+	if got, err := a.Run("hello"); err != nil || got != "ok" {
+		t.Fatalf("Run = %q, %v, want ok", got, err)
+	}
+}
+
+func TestStreamHandlerIsNotCalledConcurrently(t *testing.T) {
+	llm := LLMFunc(func(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+		for i := 0; i < 50; i++ {
+			req.OnToken("x")
+		}
+		return &GenerateResponse{Response: "x"}, nil
+	})
+	var active, overlaps, tokens int32
+	a := newTestAgent(llm, WithStreamHandler(func(token StreamToken) {
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		tokens++
+		atomic.AddInt32(&active, -1)
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.generate(context.Background(), StageSummarize, LLMRequest{Prompt: "page"})
+		}()
+	}
+	wg.Wait()
+	if overlaps != 0 || tokens != 400 {
+		t.Errorf("overlapping calls = %d, tokens = %d, want 0 and 400", overlaps, tokens)
+	}
+}
+
+func TestRunStopsWhenCanceled(t *testing.T) {
+	started := make(chan struct{})
+	llm := LLMFunc(func(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+		if req.Stage != StageAction {
+			return &GenerateResponse{Response: req.Prompt}, nil
+		}
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	a := newTestAgent(llm)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := a.RunContext(ctx, "hello"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("RunContext error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestToolsReceiveTheRunContext(t *testing.T) {
+	type key struct{}
+	var got interface{}
+	probe := NewTool("Probe", "Probes the context", "anything", func(ctx context.Context, input string) (string, error) {
+		got = ctx.Value(key{})
+		return "probed", nil
+	})
+	llm := &fakeLLM{replies: []string{"Function: Probe\nInput: x", "Function: Finish\nInput: done"}}
+	a := newTestAgent(llm, WithTools(probe))
+
+	ctx := context.WithValue(context.Background(), key{}, "run")
+	if _, err := a.RunContext(ctx, "hello"); err != nil {
+		t.Fatalf("RunContext: %v", err)
+	}
+	if got != "run" {
+		t.Errorf("tool context value = %v, want run", got)
+	}
+}
+
+func TestTokenCounterFollowsTheActionModel(t *testing.T) {
+	a := NewAgent(WithLLM(&fakeLLM{}), WithModel("unknown-model"))
+	if a.tokenCounter() != prompt.TokenCounter(prompt.ModelTokenCounter("unknown-model")) {
+		t.Error("token counter does not match the agent model")
+	}
+	a = NewAgent(WithLLM(&fakeLLM{}), WithModel("unknown-model"), WithStageModel(StageAction, "zephyr"))
+	if a.tokenCounter() != prompt.TokenCounter(prompt.ModelTokenCounter("zephyr")) {
+		t.Error("token counter does not match the action stage model")
+	}
+	counter := prompt.HeuristicCounter{}
+	if a := newTestAgent(&fakeLLM{}, WithTokenCounter(counter)); a.tokenCounter() != prompt.TokenCounter(counter) {
+		t.Error("configured token counter is not used")
+	}
+}
+
+// requestTokens counts the tokens of req as sent to a chat backend.
+func requestTokens(t *testing.T, a *Agent, req LLMRequest) int {
+	t.Helper()
+	text := req.System + req.Prompt
+	for _, msg := range req.Messages {
+		text += msg.String()
+	}
+	n, err := a.tokenCounter().CountTokens(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestChatRequestsFitMaxLength(t *testing.T) {
+	a := newTestAgent(&chatLLM{}, WithMaxLength(60))
+	a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", "Tell me about gophers")
+
+	req, err := a.newRequest("You are an AI Assistant.", "Tell me about gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Messages) != 1 || req.Prompt != "" {
+		t.Errorf("short conversation: messages = %v, prompt = %q", req.Messages, req.Prompt)
+	}
+
+	for i := 0; i < 20; i++ {
+		a.MessageHistory.AddMessage(messages.FunctionResult, "System", "Lookup", fmt.Sprintf("Result %d says gophers dig tunnels.", i))
+	}
+	req, err = a.newRequest("You are an AI Assistant.", "Tell me about gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := requestTokens(t, a, req); n > 60 {
+		t.Errorf("request has %d tokens, more than 60", n)
+	}
+	if len(req.Messages) == 0 || req.Messages[len(req.Messages)-1].Content != "Result 19 says gophers dig tunnels." {
+		t.Errorf("newest messages were not kept: %v", req.Messages)
+	}
+	if req.Prompt != "Tell me about gophers" {
+		t.Errorf("prompt = %q, want the task once the first message is left out", req.Prompt)
+	}
+}
+
+func TestChatRequestsCutALongNewestMessage(t *testing.T) {
+	a := newTestAgent(&chatLLM{}, WithMaxLength(60))
+	a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", "Summarize this page")
+	a.MessageHistory.AddMessage(messages.FunctionResult, "System", "Browse", strings.Repeat("gophers dig tunnels ", 100))
+
+	req, err := a.newRequest("You are an AI Assistant.", "Summarize this page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Messages) != 1 || !strings.HasPrefix(req.Messages[0].Content, "gophers dig tunnels") {
+		t.Fatalf("messages = %v, want the newest message cut down", req.Messages)
+	}
+	if n := requestTokens(t, a, req); n > 60 {
+		t.Errorf("request has %d tokens, more than 60", n)
+	}
+}
@@ -0,0 +1,300 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
+)
+
+// ErrBrowserPoolClosed is returned when using a BrowserPool after Close.
+var ErrBrowserPoolClosed = errors.New("browser pool is closed")
+
+// BrowserPoolOptions configures a BrowserPool. Zero values select the defaults.
+type BrowserPoolOptions struct {
+	Instances       int           // Number of Chrome processes, defaults to 1
+	TabsPerInstance int           // Concurrent tabs per Chrome process, defaults to 4
+	IdleTimeout     time.Duration // Chrome is shut down after being idle this long, defaults to 5 minutes
+	ExecOptions     []chromedp.ExecAllocatorOption
+}
+
+// BrowserPool keeps headless Chrome processes running between calls and
+// hands out tabs to concurrent callers. Every tab runs in its own incognito
+// browser context, so calls do not share cookies or storage. Chrome is
+// started on first use, stopped after IdleTimeout and restarted on demand.
+type BrowserPool struct {
+	opts BrowserPoolOptions
+	tabs chan struct{} // Limits concurrent tabs across all instances
+
+	mu        sync.Mutex
+	ready     *sync.Cond // Signalled when a Chrome start finishes or the pool closes
+	instances []*browserInstance
+	starting  int // Chrome processes being started outside mu
+	next      int
+	active    int
+	idle      *time.Timer
+	idleArmed int // Idle timers armed so far, tells the current timer from stale ones
+	closed    bool
+
+	launch func() (*browserInstance, error) // Starts Chrome, replaced in tests
+}
+
+type browserInstance struct {
+	browserCtx context.Context
+	cancel     context.CancelFunc
+}
+
+// NewBrowserPool creates a pool. Chrome is not started until the first call.
+func NewBrowserPool(opts BrowserPoolOptions) *BrowserPool {
+	if opts.Instances <= 0 {
+		opts.Instances = 1
+	}
+	if opts.TabsPerInstance <= 0 {
+		opts.TabsPerInstance = 4
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 5 * time.Minute
+	}
+	if opts.ExecOptions == nil {
+		opts.ExecOptions = append(chromedp.DefaultExecAllocatorOptions[:], chromedp.DisableGPU)
+	}
+	p := &BrowserPool{
+		opts: opts,
+		tabs: make(chan struct{}, opts.Instances*opts.TabsPerInstance),
+	}
+	p.ready = sync.NewCond(&p.mu)
+	p.launch = p.start
+	return p
+}
+
+var (
+	sharedBrowserPool     *BrowserPool
+	sharedBrowserPoolOnce sync.Once
+)
+
+// defaultBrowserPool returns the pool shared by Browse and fetchers without a pool of their own.
+func defaultBrowserPool() *BrowserPool {
+	sharedBrowserPoolOnce.Do(func() {
+		sharedBrowserPool = NewBrowserPool(BrowserPoolOptions{})
+	})
+	return sharedBrowserPool
+}
+
+// Run runs actions in a fresh incognito tab. The tab is closed when Run
+// returns or ctx is done, whichever comes first.
+func (p *BrowserPool) Run(ctx context.Context, actions ...chromedp.Action) error {
+	select {
+	case p.tabs <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.tabs }()
+
+	instance, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer p.release()
+
+	tabCtx, cancel := chromedp.NewContext(instance.browserCtx, chromedp.WithNewBrowserContext())
+	defer cancel()
+
+	// chromedp contexts derive from the browser, so bind the tab to ctx by hand.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-done:
+		}
+	}()
+
+	err = chromedp.Run(tabCtx, actions...)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// acquire returns a running instance, starting Chrome if needed. Chrome is
+// started without holding p.mu, so other calls and Close are not blocked
+// while it launches.
+func (p *BrowserPool) acquire() (*browserInstance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if p.closed {
+			return nil, ErrBrowserPoolClosed
+		}
+		if len(p.instances)+p.starting < p.opts.Instances {
+			break
+		}
+		if len(p.instances) > 0 {
+			instance := p.instances[p.next%len(p.instances)]
+			p.next++
+			p.markActive()
+			return instance, nil
+		}
+		// Every instance is still starting; wait for one of them.
+		p.ready.Wait()
+	}
+
+	p.starting++
+	p.mu.Unlock()
+	instance, err := p.launch()
+	p.mu.Lock()
+	p.starting--
+	p.ready.Broadcast()
+	if err != nil {
+		return nil, err
+	}
+	if p.closed {
+		instance.cancel()
+		return nil, ErrBrowserPoolClosed
+	}
+	p.instances = append(p.instances, instance)
+	p.markActive()
+	return instance, nil
+}
+
+// markActive counts a call in flight and stops the idle timer.
+// The caller must hold p.mu.
+func (p *BrowserPool) markActive() {
+	if p.idle != nil {
+		p.idle.Stop()
+		p.idle = nil
+	}
+	p.active++
+}
+
+// start launches a Chrome process with its own temporary profile directory.
+func (p *BrowserPool) start() (*browserInstance, error) {
+	allocatorCtx, allocatorCancel := chromedp.NewExecAllocator(context.Background(), p.opts.ExecOptions...)
+	browserCtx, browserCancel := chromedp.NewContext(allocatorCtx)
+	if err := chromedp.Run(browserCtx); err != nil {
+		browserCancel()
+		allocatorCancel()
+		return nil, err
+	}
+	return &browserInstance{
+		browserCtx: browserCtx,
+		cancel: func() {
+			browserCancel()
+			allocatorCancel()
+		},
+	}, nil
+}
+
+// release marks a call as finished and arms the idle timer when none are left.
+func (p *BrowserPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	if p.active == 0 && !p.closed && len(p.instances) > 0 {
+		p.idleArmed++
+		armed := p.idleArmed
+		p.idle = time.AfterFunc(p.opts.IdleTimeout, func() { p.shutdownIdle(armed) })
+	}
+}
+
+// shutdownIdle stops Chrome if the pool is still idle. A timer that fired
+// while a call was starting is stale once release arms a new one, so only
+// the current timer shuts down.
+func (p *BrowserPool) shutdownIdle(armed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if armed != p.idleArmed || p.active > 0 {
+		return
+	}
+	p.idle = nil
+	p.stopInstances()
+}
+
+func (p *BrowserPool) stopInstances() {
+	for _, instance := range p.instances {
+		instance.cancel()
+	}
+	p.instances = nil
+	p.next = 0
+}
+
+// Close stops all Chrome processes. Calls in flight are cancelled and later calls fail.
+func (p *BrowserPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	p.ready.Broadcast()
+	if p.idle != nil {
+		p.idle.Stop()
+		p.idle = nil
+	}
+	p.stopInstances()
+	return nil
+}
+
+// Fetch renders url in a pooled tab and dumps its DOM tree.
+func (p *BrowserPool) Fetch(ctx context.Context, url string) (string, error) {
+	var nodes []*cdp.Node
+	var sb strings.Builder
+	err := p.Run(ctx,
+		chromedp.Emulate(device.IPhone13),
+		chromedp.Navigate(url),
+		chromedp.WaitVisible(`body`, chromedp.ByQuery),
+		chromedp.Nodes(`html`, &nodes, chromedp.ByQuery),
+		chromedp.ActionFunc(func(c context.Context) error {
+			return dom.RequestChildNodes(nodes[0].NodeID).WithDepth(-1).Do(c)
+		}),
+		chromedp.Sleep(1*time.Second),
+		chromedp.ActionFunc(func(c context.Context) error {
+			printNodes(&sb, nodes, "", "  ", 20)
+			return nil
+		}),
+	)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// WithBrowserPool makes the agent render JavaScript heavy pages with pool
+// instead of a pool of its own. The agent closes pool when it is closed.
+func WithBrowserPool(pool *BrowserPool) AgentOption {
+	return func(a *Agent) {
+		a.Browser = pool
+	}
+}
+
+// browserPool returns the agent's browser pool, creating it on first use.
+func (a *Agent) browserPool() *BrowserPool {
+	a.browserMu.Lock()
+	defer a.browserMu.Unlock()
+	if a.Browser == nil {
+		a.Browser = NewBrowserPool(BrowserPoolOptions{})
+	}
+	return a.Browser
+}
+
+// renderPage renders url with the agent's browser pool.
+func (a *Agent) renderPage(ctx context.Context, url string) (string, error) {
+	return a.browserPool().Fetch(ctx, url)
+}
+
+// Close releases resources held by the agent, such as its browser pool.
+func (a *Agent) Close() error {
+	a.browserMu.Lock()
+	defer a.browserMu.Unlock()
+	if a.Browser != nil {
+		return a.Browser.Close()
+	}
+	return nil
+}
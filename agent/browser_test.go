@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLaunch replaces Chrome in p. Every launch signals started and waits for
+// proceed before returning a fresh instance or err.
+type fakeLaunch struct {
+	started  chan struct{}
+	proceed  chan error
+	launches int32
+	stopped  int32
+}
+
+func newFakeLaunch(p *BrowserPool) *fakeLaunch {
+	f := &fakeLaunch{started: make(chan struct{}, 8), proceed: make(chan error, 8)}
+	p.launch = func() (*browserInstance, error) {
+		atomic.AddInt32(&f.launches, 1)
+		f.started <- struct{}{}
+		if err := <-f.proceed; err != nil {
+			return nil, err
+		}
+		return &browserInstance{
+			browserCtx: context.Background(),
+			cancel:     func() { atomic.AddInt32(&f.stopped, 1) },
+		}, nil
+	}
+	return f
+}
+
+func TestCloseIsNotBlockedByAStartingBrowser(t *testing.T) {
+	p := NewBrowserPool(BrowserPoolOptions{})
+	launch := newFakeLaunch(p)
+
+	result := make(chan error)
+	go func() {
+		_, err := p.acquire()
+		result <- err
+	}()
+	<-launch.started
+
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for Chrome to start")
+	}
+
+	launch.proceed <- nil
+	if err := <-result; !errors.Is(err, ErrBrowserPoolClosed) {
+		t.Errorf("acquire = %v, want ErrBrowserPoolClosed", err)
+	}
+	if launch.stopped != 1 {
+		t.Errorf("instance started after Close was not stopped")
+	}
+}
+
+func TestCallersShareAStartingBrowser(t *testing.T) {
+	p := NewBrowserPool(BrowserPoolOptions{Instances: 1})
+	launch := newFakeLaunch(p)
+
+	var wg sync.WaitGroup
+	instances := make([]*browserInstance, 3)
+	for i := range instances {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instance, err := p.acquire()
+			if err != nil {
+				t.Errorf("acquire: %v", err)
+			}
+			instances[i] = instance
+		}(i)
+	}
+	<-launch.started
+	launch.proceed <- nil
+	wg.Wait()
+
+	if launch.launches != 1 {
+		t.Errorf("launches = %d, want 1", launch.launches)
+	}
+	for _, instance := range instances[1:] {
+		if instance != instances[0] {
+			t.Error("callers got different instances from a single instance pool")
+		}
+	}
+	if p.active != 3 {
+		t.Errorf("active = %d, want 3", p.active)
+	}
+}
+
+func TestFailedStartIsRetried(t *testing.T) {
+	p := NewBrowserPool(BrowserPoolOptions{IdleTimeout: time.Hour})
+	launch := newFakeLaunch(p)
+	launch.proceed <- errors.New("no chrome")
+	launch.proceed <- nil
+
+	if _, err := p.acquire(); err == nil {
+		t.Fatal("first acquire succeeded")
+	}
+	if _, err := p.acquire(); err != nil {
+		t.Fatalf("second acquire: %v", err)
+	}
+	p.release()
+	if p.idle == nil {
+		t.Error("idle timer not armed after the last release")
+	}
+	p.Close()
+	if launch.stopped != 1 {
+		t.Errorf("stopped = %d, want 1", launch.stopped)
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestIdleBrowserIsStoppedAndRelaunched(t *testing.T) {
+	const idle = 20 * time.Millisecond
+	p := NewBrowserPool(BrowserPoolOptions{IdleTimeout: idle})
+	defer p.Close()
+	launch := newFakeLaunch(p)
+	stopped := func(n int32) func() bool {
+		return func() bool { return atomic.LoadInt32(&launch.stopped) == n }
+	}
+	launch.proceed <- nil
+	launch.proceed <- nil
+
+	if _, err := p.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	p.release()
+	waitFor(t, "the idle shutdown", stopped(1))
+	p.mu.Lock()
+	if len(p.instances) != 0 || p.idle != nil {
+		t.Errorf("instances = %d, idle timer = %v after the idle shutdown", len(p.instances), p.idle)
+	}
+	p.mu.Unlock()
+
+	// The next call relaunches Chrome, and calls in flight keep it running.
+	if _, err := p.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&launch.launches); n != 2 {
+		t.Errorf("launches = %d, want 2", n)
+	}
+	p.release()
+	time.Sleep(3 * idle)
+	if !stopped(1)() {
+		t.Fatal("Chrome was stopped with a call in flight")
+	}
+	p.release()
+	waitFor(t, "the second idle shutdown", stopped(2))
+}
+
+func TestStaleIdleTimerDoesNotStopChrome(t *testing.T) {
+	p := NewBrowserPool(BrowserPoolOptions{IdleTimeout: time.Hour})
+	defer p.Close()
+	launch := newFakeLaunch(p)
+	launch.proceed <- nil
+
+	if _, err := p.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	p.release()
+	stale := p.idleArmed
+	// A call arrives as the timer fires, and its release re-arms the timer.
+	if _, err := p.acquire(); err != nil {
+		t.Fatal(err)
+	}
+	p.release()
+	p.shutdownIdle(stale)
+	if launch.stopped != 0 {
+		t.Error("a stale idle timer stopped Chrome")
+	}
+	if p.idle == nil || p.idleArmed == stale {
+		t.Error("release did not arm a new idle timer")
+	}
+}
+
+func TestAgentCreatesItsBrowserPoolLazily(t *testing.T) {
+	a := newTestAgent(&fakeLLM{})
+	if a.Browser != nil {
+		t.Fatal("NewAgent created a browser pool")
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Close without a pool: %v", err)
+	}
+
+	pool := NewBrowserPool(BrowserPoolOptions{})
+	a = NewAgent(WithLLM(&fakeLLM{}), WithBrowserPool(pool))
+	if a.browserPool() != pool {
+		t.Error("WithBrowserPool was not used")
+	}
+	if _, ok := a.Tools.Get("Browse"); !ok {
+		t.Error("Browse is not registered")
+	}
+	a.Close()
+	if _, err := pool.acquire(); !errors.Is(err, ErrBrowserPoolClosed) {
+		t.Errorf("pool not closed with the agent: %v", err)
+	}
+}
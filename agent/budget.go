@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Budget limits the resources a single run may use. Zero values mean unlimited.
+type Budget struct {
+	MaxSteps            int           // Maximum number of function calls chosen by the model
+	MaxPromptTokens     int           // Maximum prompt tokens summed over all model requests
+	MaxCompletionTokens int           // Maximum completion tokens summed over all model requests
+	MaxTotalTokens      int           // Maximum prompt plus completion tokens
+	MaxDuration         time.Duration // Maximum wall-clock time of the run
+}
+
+// Usage records the resources used by the current or last run.
+type Usage struct {
+	Steps            int
+	PromptTokens     int
+	CompletionTokens int
+	Duration         time.Duration
+}
+
+// TotalTokens returns the sum of prompt and completion tokens.
+func (u Usage) TotalTokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// BudgetKind names the budget that was exceeded.
+type BudgetKind string
+
+const (
+	BudgetSteps            BudgetKind = "steps"
+	BudgetPromptTokens     BudgetKind = "prompt_tokens"
+	BudgetCompletionTokens BudgetKind = "completion_tokens"
+	BudgetTotalTokens      BudgetKind = "total_tokens"
+	BudgetDuration         BudgetKind = "duration"
+)
+
+// BudgetExceededError is returned by Run when a budget runs out.
+// Run returns the best partial result alongside it.
+type BudgetExceededError struct {
+	Kind   BudgetKind
+	Budget Budget
+	Usage  Usage
+}
+
+func (e *BudgetExceededError) Error() string {
+	switch e.Kind {
+	case BudgetSteps:
+		return fmt.Sprintf("budget exceeded: %d of %d steps used", e.Usage.Steps, e.Budget.MaxSteps)
+	case BudgetPromptTokens:
+		return fmt.Sprintf("budget exceeded: %d of %d prompt tokens used", e.Usage.PromptTokens, e.Budget.MaxPromptTokens)
+	case BudgetCompletionTokens:
+		return fmt.Sprintf("budget exceeded: %d of %d completion tokens used", e.Usage.CompletionTokens, e.Budget.MaxCompletionTokens)
+	case BudgetTotalTokens:
+		return fmt.Sprintf("budget exceeded: %d of %d total tokens used", e.Usage.TotalTokens(), e.Budget.MaxTotalTokens)
+	case BudgetDuration:
+		return fmt.Sprintf("budget exceeded: ran for %s of %s", e.Usage.Duration.Round(time.Millisecond), e.Budget.MaxDuration)
+	}
+	return fmt.Sprintf("budget exceeded: %s", e.Kind)
+}
+
+// WithBudget limits steps, tokens and wall-clock time of every run.
+func WithBudget(budget Budget) AgentOption {
+	return func(a *Agent) {
+		a.Budget = budget
+	}
+}
+
+// Usage returns the resources used by the current or last run.
+func (a *Agent) Usage() Usage {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	usage := a.usage
+	if !a.runStart.IsZero() {
+		usage.Duration = time.Since(a.runStart)
+	}
+	return usage
+}
+
+// startUsage resets usage for a new run and applies the duration budget to ctx.
+func (a *Agent) startUsage(ctx context.Context) (context.Context, context.CancelFunc) {
+	a.usageMu.Lock()
+	a.usage = Usage{}
+	a.events = nil
+	a.runStart = time.Now()
+	a.usageMu.Unlock()
+	if a.Budget.MaxDuration > 0 {
+		return context.WithTimeout(ctx, a.Budget.MaxDuration)
+	}
+	return context.WithCancel(ctx)
+}
+
+// finishUsage freezes the duration of the run.
+func (a *Agent) finishUsage() {
+	a.usageMu.Lock()
+	a.usage.Duration = time.Since(a.runStart)
+	a.runStart = time.Time{}
+	a.usageMu.Unlock()
+}
+
+func (a *Agent) addTokens(resp *GenerateResponse) {
+	a.usageMu.Lock()
+	a.usage.PromptTokens += resp.PromptEvalCount
+	a.usage.CompletionTokens += resp.EvalCount
+	a.usageMu.Unlock()
+}
+
+func (a *Agent) addStep() {
+	a.usageMu.Lock()
+	a.usage.Steps++
+	a.usageMu.Unlock()
+}
+
+// checkBudget returns a *BudgetExceededError if any budget has run out.
+// parent is the caller's context, used to tell the duration budget apart
+// from a deadline or cancellation set by the caller.
+func (a *Agent) checkBudget(parent, ctx context.Context) error {
+	usage := a.Usage()
+	b := a.Budget
+	switch {
+	case b.MaxDuration > 0 && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil:
+		return &BudgetExceededError{Kind: BudgetDuration, Budget: b, Usage: usage}
+	case b.MaxSteps > 0 && usage.Steps >= b.MaxSteps:
+		return &BudgetExceededError{Kind: BudgetSteps, Budget: b, Usage: usage}
+	}
+	return a.checkTokenBudget()
+}
+
+// checkTokenBudget returns a *BudgetExceededError if a token budget has run out.
+// generate checks it before every request, so repairs, page digests and
+// intent inference cannot overshoot the budget either.
+func (a *Agent) checkTokenBudget() error {
+	usage := a.Usage()
+	b := a.Budget
+	var kind BudgetKind
+	switch {
+	case b.MaxPromptTokens > 0 && usage.PromptTokens >= b.MaxPromptTokens:
+		kind = BudgetPromptTokens
+	case b.MaxCompletionTokens > 0 && usage.CompletionTokens >= b.MaxCompletionTokens:
+		kind = BudgetCompletionTokens
+	case b.MaxTotalTokens > 0 && usage.TotalTokens() >= b.MaxTotalTokens:
+		kind = BudgetTotalTokens
+	default:
+		return nil
+	}
+	return &BudgetExceededError{Kind: kind, Budget: b, Usage: usage}
+}
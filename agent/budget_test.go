@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunStopsAtTheStepBudget(t *testing.T) {
+	llm := &fakeLLM{replies: []string{
+		"Function: Echo\nInput: one",
+		"Function: Echo\nInput: two",
+		"Function: Finish\nInput: done",
+	}}
+	echo := NewTool("Echo", "Echoes its input", "text", func(ctx context.Context, input string) (string, error) {
+		return input, nil
+	})
+	a := newTestAgent(llm, WithTools(echo), WithBudget(Budget{MaxSteps: 2}))
+
+	result, err := a.Run("hello")
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) || budgetErr.Kind != BudgetSteps {
+		t.Fatalf("Run error = %v, want a step budget error", err)
+	}
+	if result != "two" {
+		t.Errorf("partial result = %q, want two", result)
+	}
+}
+
+func TestRepairsStopAtTheTokenBudget(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"gibberish", "more gibberish", "still gibberish", "Function: Finish\nInput: done"}}
+	a := newTestAgent(llm,
+		WithBudget(Budget{MaxTotalTokens: 20}),
+		WithRepair(RepairOptions{MaxAttempts: 5, Reask: true}),
+	)
+
+	_, err := a.Run("hello")
+	var budgetErr *BudgetExceededError
+	if !errors.As(err, &budgetErr) || budgetErr.Kind != BudgetTotalTokens {
+		t.Fatalf("Run error = %v, want a total token budget error", err)
+	}
+	// Every action request uses 15 tokens, so the second one exhausts the budget.
+	if n := len(llm.stageRequests(StageAction)); n != 2 {
+		t.Errorf("action requests = %d, want 2", n)
+	}
+}
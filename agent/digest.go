@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bgokden/miniagent/chunking"
+)
+
+// DigestOptions configures how fetched pages are digested before they are
+// added to the conversation. Zero values select the defaults.
+type DigestOptions struct {
+	Disabled    bool // Add pages to the conversation as fetched
+	ChunkTokens int  // Tokens per map chunk, defaults to 1000
+	MaxChunks   int  // Chunks digested per page, the rest is dropped, defaults to 8
+	Concurrency int  // Chunks summarized in parallel, defaults to 4
+}
+
+// WithDigest configures page digestion.
+func WithDigest(opts DigestOptions) AgentOption {
+	return func(a *Agent) {
+		a.Digest = opts
+	}
+}
+
+func (o DigestOptions) withDefaults() DigestOptions {
+	if o.ChunkTokens <= 0 {
+		o.ChunkTokens = 1000
+	}
+	if o.MaxChunks <= 0 {
+		o.MaxChunks = 8
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 4
+	}
+	return o
+}
+
+// digestPage summarizes content fetched from source against topic with a
+// map-reduce over token-sized chunks. The first chunk is summarized alone so
+// an unrelated page is dropped after a single model call. Failed chunks are
+// skipped, but digestPage fails if the first chunk or all others fail.
+func (a *Agent) digestPage(ctx context.Context, topic, source, content string) (string, error) {
+	opts := a.Digest.withDefaults()
+	// Only split the part of the page that MaxChunks chunks can hold.
+	content, cut := cutRunes(content, opts.MaxChunks*opts.ChunkTokens*maxRunesPerToken)
+	chunks, err := a.splitIntoChunks(content, opts.ChunkTokens)
+	if err != nil {
+		return "", err
+	}
+	if len(chunks) == 0 {
+		return fmt.Sprintf("URL: %s\nContent: The page is empty.\nIsRelated: No\n", source), nil
+	}
+	if cut || len(chunks) > opts.MaxChunks {
+		log.Printf("Digesting the first %d chunks of %s", minInt(len(chunks), opts.MaxChunks), source)
+		chunks = chunks[:minInt(len(chunks), opts.MaxChunks)]
+	}
+
+	first, err := a.summarizeChunk(ctx, topic, chunks[0], 1)
+	if err != nil {
+		return "", err
+	}
+	if !findIsRelatedStatus(first) || len(chunks) == 1 {
+		return formatDigest(source, first, nil), nil
+	}
+
+	summaries := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+	summaries[0] = first
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i := 1; i < len(chunks); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			summaries[i], errs[i] = a.summarizeChunk(ctx, topic, chunks[i], i+1)
+		}(i)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var related []string
+	var failed []error
+	for i, summary := range summaries {
+		if errs[i] != nil {
+			log.Printf("Summarizing chunk %d of %s failed: %v", i+1, source, errs[i])
+			failed = append(failed, fmt.Errorf("chunk %d: %w", i+1, errs[i]))
+			continue
+		}
+		if findIsRelatedStatus(summary) {
+			related = append(related, summary)
+		}
+	}
+	if len(failed) == len(chunks)-1 {
+		return "", fmt.Errorf("summarizing %s: %w", source, errors.Join(failed...))
+	}
+	links := collectLinks(related)
+	switch len(related) {
+	case 0:
+		return formatDigest(source, first, nil), nil
+	case 1:
+		return formatDigest(source, related[0], nil), nil
+	}
+
+	reduced, err := a.reduceSummaries(ctx, topic, related)
+	if err != nil {
+		return "", err
+	}
+	return formatDigest(source, reduced, links), nil
+}
+
+// summarizeChunk is the map step of digestPage for the chunk with the given
+// number, counted from 1.
+func (a *Agent) summarizeChunk(ctx context.Context, topic, text string, chunk int) (string, error) {
+	prompt := fmt.Sprintf(`For the topic '%s', please extract essential information from the given part of a web page,
+concentrating on the main body text, headings, and significant hyperlinks.
+Summarize the central themes or key information related to the specified topic,
+ensuring the summary is succinct and directly relevant.
+Exclude any details about website technologies or unrelated content.
+Additionally, identify if the content is relevant to the given topic.
+Provide a list of the most pertinent links for additional reading or context, disregarding cookie and consent notices.
+The output should be formatted as follows:
+
+Content: [Concise summary focusing on the topic]
+IsRelated: [Yes/No, based on relevance to the topic]
+Links: [Relevant links for further information]
+- Link 1
+- Link 2
+
+WebPage as input:`, topic)
+	response, err := a.generate(ctx, StageSummarize, LLMRequest{System: prompt, Prompt: text, OnToken: a.streamTo(StageSummarize, chunk)})
+	if err != nil {
+		return "", err
+	}
+	return response.Response, nil
+}
+
+// reduceSummaries is the reduce step of digestPage.
+func (a *Agent) reduceSummaries(ctx context.Context, topic string, summaries []string) (string, error) {
+	prompt := fmt.Sprintf(`For the topic '%s', combine the following extracts of one web page into a single summary.
+Keep every fact related to the topic, remove repetitions and keep the most pertinent links.
+The output should be formatted as follows:
+
+Content: [Concise summary focusing on the topic]
+IsRelated: Yes
+Links: [Relevant links for further information]
+- Link 1
+- Link 2
+
+Extracts as input:`, topic)
+	var input strings.Builder
+	for i, summary := range summaries {
+		input.WriteString(fmt.Sprintf("Extract %d:\n%s\n\n", i+1, strings.TrimSpace(summary)))
+	}
+	response, err := a.generate(ctx, StageSummarize, LLMRequest{System: prompt, Prompt: input.String()})
+	if err != nil {
+		return "", err
+	}
+	return response.Response, nil
+}
+
+// formatDigest prefixes summary with its source and appends links from the
+// map step that the summary dropped.
+func formatDigest(source, summary string, links []string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("URL: %s\n", source))
+	b.WriteString(strings.TrimSpace(summary))
+	b.WriteString("\n")
+	var missing []string
+	for _, link := range links {
+		if !strings.Contains(summary, link) {
+			missing = append(missing, link)
+		}
+	}
+	if len(missing) > 0 {
+		b.WriteString("More Links:\n")
+		for _, link := range missing {
+			b.WriteString(fmt.Sprintf("- %s\n", link))
+		}
+	}
+	return b.String()
+}
+
+// collectLinks returns the distinct links listed in the Links sections of summaries.
+func collectLinks(summaries []string) []string {
+	var links []string
+	seen := map[string]bool{}
+	for _, summary := range summaries {
+		inLinks := false
+		for _, line := range strings.Split(summary, "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "Links:") {
+				inLinks = true
+				continue
+			}
+			if !inLinks || !strings.HasPrefix(line, "- ") {
+				continue
+			}
+			link := strings.TrimSpace(strings.TrimPrefix(line, "- "))
+			if link != "" && !seen[link] {
+				seen[link] = true
+				links = append(links, link)
+			}
+		}
+	}
+	return links
+}
+
+// maxRunesPerToken bounds the characters a token spans, generously, so that
+// cutting a page to maxRunesPerToken runes per token keeps enough text.
+const maxRunesPerToken = 16
+
+// cutRunes returns the first n runes of s and whether anything was cut.
+func cutRunes(s string, n int) (string, bool) {
+	for i := range s {
+		if n == 0 {
+			return s[:i], true
+		}
+		n--
+	}
+	return s, false
+}
+
+// splitIntoChunks splits text into chunks of at most maxTokens tokens,
+// preferring paragraph, then sentence, then word boundaries.
+func (a *Agent) splitIntoChunks(text string, maxTokens int) ([]string, error) {
+	return chunking.Split(text, chunking.Options{
+		MaxTokens: maxTokens,
+		Overlap:   maxTokens / 20,
+		Counter:   a.tokenCounter().CountTokens,
+	})
+}
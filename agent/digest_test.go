@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bgokden/miniagent/prompt"
+)
+
+// digestLLM summarizes chunks by their first word: "skip" chunks are
+// unrelated, "fail" chunks fail and every other chunk is related.
+type digestLLM struct {
+	mu      sync.Mutex
+	maps    int
+	reduces int
+}
+
+func (d *digestLLM) Generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if strings.Contains(req.System, "combine the following extracts") {
+		d.reduces++
+		return &GenerateResponse{Response: "Content: combined\nIsRelated: Yes\nLinks:\n- https://example.com/a"}, nil
+	}
+	d.maps++
+	word := strings.Fields(req.Prompt)[0]
+	switch word {
+	case "skip":
+		return &GenerateResponse{Response: "Content: nothing useful\nIsRelated: No"}, nil
+	case "fail":
+		return nil, errors.New("model overloaded")
+	}
+	return &GenerateResponse{Response: fmt.Sprintf("Content: about %s\nIsRelated: Yes\nLinks:\n- https://example.com/%s", word, word)}, nil
+}
+
+// page joins paragraphs that start with the given words. Each paragraph is
+// a chunk of its own with 20 token chunks.
+func page(words ...string) string {
+	paragraphs := make([]string, len(words))
+	for i, word := range words {
+		paragraphs[i] = word + " is how this paragraph starts, and it continues for a little while."
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func newDigestAgent(llm LLM) *Agent {
+	return newTestAgent(llm, WithDigest(DigestOptions{ChunkTokens: 20}))
+}
+
+func TestDigestPageMapReduce(t *testing.T) {
+	llm := &digestLLM{}
+	a := newDigestAgent(llm)
+
+	digest, err := a.digestPage(context.Background(), "topic", "https://example.com", page("a", "b", "skip", "c"))
+	if err != nil {
+		t.Fatalf("digestPage: %v", err)
+	}
+	if llm.maps != 4 || llm.reduces != 1 {
+		t.Errorf("map calls = %d, reduce calls = %d, want 4 and 1", llm.maps, llm.reduces)
+	}
+	want := "URL: https://example.com\nContent: combined\nIsRelated: Yes\nLinks:\n- https://example.com/a\n" +
+		"More Links:\n- https://example.com/b\n- https://example.com/c\n"
+	if digest != want {
+		t.Errorf("digest =\n%s\nwant\n%s", digest, want)
+	}
+}
+
+func TestDigestPageStopsAtAnUnrelatedFirstChunk(t *testing.T) {
+	llm := &digestLLM{}
+	a := newDigestAgent(llm)
+
+	digest, err := a.digestPage(context.Background(), "topic", "https://example.com", page("skip", "a", "b"))
+	if err != nil {
+		t.Fatalf("digestPage: %v", err)
+	}
+	if llm.maps != 1 || !strings.Contains(digest, "IsRelated: No") {
+		t.Errorf("map calls = %d, digest = %q", llm.maps, digest)
+	}
+}
+
+func TestDigestPageSkipsFailedChunks(t *testing.T) {
+	llm := &digestLLM{}
+	a := newDigestAgent(llm)
+
+	digest, err := a.digestPage(context.Background(), "topic", "https://example.com", page("a", "fail", "b"))
+	if err != nil {
+		t.Fatalf("digestPage: %v", err)
+	}
+	if llm.reduces != 1 || !strings.Contains(digest, "combined") {
+		t.Errorf("digest = %q after %d reduce calls", digest, llm.reduces)
+	}
+}
+
+func TestDigestPageFailsWhenNoChunkIsSummarized(t *testing.T) {
+	a := newDigestAgent(&digestLLM{})
+	ctx := context.Background()
+
+	if _, err := a.digestPage(ctx, "topic", "https://example.com", page("fail", "a")); err == nil {
+		t.Error("failed first chunk returned no error")
+	}
+	_, err := a.digestPage(ctx, "topic", "https://example.com", page("a", "fail", "fail"))
+	if err == nil || !strings.Contains(err.Error(), "chunk 2: model overloaded") || !strings.Contains(err.Error(), "chunk 3") {
+		t.Errorf("error = %v, want the errors of chunks 2 and 3", err)
+	}
+}
+
+func TestDigestPageLimitsChunks(t *testing.T) {
+	llm := &digestLLM{}
+	a := newTestAgent(llm, WithDigest(DigestOptions{ChunkTokens: 20, MaxChunks: 2}))
+
+	if _, err := a.digestPage(context.Background(), "topic", "https://example.com", page("a", "b", "c", "d")); err != nil {
+		t.Fatalf("digestPage: %v", err)
+	}
+	if llm.maps != 2 {
+		t.Errorf("map calls = %d, want 2", llm.maps)
+	}
+}
+
+// longestCounter counts like HeuristicCounter and records the longest text counted.
+type longestCounter struct {
+	mu      sync.Mutex
+	longest int
+}
+
+func (c *longestCounter) CountTokens(s string) (int, error) {
+	c.mu.Lock()
+	if len(s) > c.longest {
+		c.longest = len(s)
+	}
+	c.mu.Unlock()
+	return prompt.HeuristicCounter{}.CountTokens(s)
+}
+
+func TestDigestPageSplitsOnlyTheChunksItDigests(t *testing.T) {
+	llm := &digestLLM{}
+	counter := &longestCounter{}
+	a := newTestAgent(llm, WithTokenCounter(counter), WithDigest(DigestOptions{ChunkTokens: 20, MaxChunks: 2}))
+
+	words := make([]string, 1000)
+	for i := range words {
+		words[i] = "a"
+	}
+	content := page(words...)
+	if _, err := a.digestPage(context.Background(), "topic", "https://example.com", content); err != nil {
+		t.Fatalf("digestPage: %v", err)
+	}
+	if llm.maps != 2 {
+		t.Errorf("map calls = %d, want 2", llm.maps)
+	}
+	if limit := 2 * 20 * maxRunesPerToken; counter.longest > limit {
+		t.Errorf("counted %d bytes of a %d byte page, want at most %d", counter.longest, len(content), limit)
+	}
+}
+
+func TestCutRunes(t *testing.T) {
+	if got, cut := cutRunes("äöü", 2); got != "äö" || !cut {
+		t.Errorf("cutRunes = %q, %v", got, cut)
+	}
+	if got, cut := cutRunes("äöü", 3); got != "äöü" || cut {
+		t.Errorf("cutRunes = %q, %v", got, cut)
+	}
+}
+
+func TestDigestPageEmpty(t *testing.T) {
+	llm := &digestLLM{}
+	digest, err := newDigestAgent(llm).digestPage(context.Background(), "topic", "https://example.com", " \n\n ")
+	if err != nil || !strings.Contains(digest, "The page is empty") || llm.maps != 0 {
+		t.Errorf("digest = %q, %v after %d calls", digest, err, llm.maps)
+	}
+}
+
+func TestDigestPageStreamsTaggedTokens(t *testing.T) {
+	llm := &digestLLM{}
+	streaming := LLMFunc(func(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+		if req.OnToken != nil {
+			// Stream the first word of the chunk twice.
+			word := strings.Fields(req.Prompt)[0]
+			req.OnToken(word)
+			req.OnToken(word)
+		}
+		return llm.Generate(ctx, req)
+	})
+	var tokens []StreamToken
+	a := newTestAgent(streaming, WithDigest(DigestOptions{ChunkTokens: 20}), WithStreamHandler(func(token StreamToken) {
+		tokens = append(tokens, token)
+	}))
+
+	if _, err := a.digestPage(context.Background(), "topic", "https://example.com", page("a", "b", "c")); err != nil {
+		t.Fatalf("digestPage: %v", err)
+	}
+	chunkWords := map[int]string{1: "a", 2: "b", 3: "c", 0: "Extract"}
+	requests := map[int]int{}
+	for _, token := range tokens {
+		if token.Stage != StageSummarize || token.Text != chunkWords[token.Chunk] {
+			t.Errorf("token %+v does not belong to its chunk", token)
+		}
+		if chunk, ok := requests[token.Request]; ok && chunk != token.Chunk {
+			t.Errorf("request %d streams chunks %d and %d", token.Request, chunk, token.Chunk)
+		}
+		requests[token.Request] = token.Chunk
+	}
+	if len(tokens) != 8 || len(requests) != 4 {
+		t.Errorf("got %d tokens of %d requests, want 8 of 4", len(tokens), len(requests))
+	}
+}
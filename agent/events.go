@@ -0,0 +1,45 @@
+package agent
+
+// EventType identifies the kind of an Event.
+type EventType string
+
+const (
+	// EventUnknownTool is recorded when the model calls a function that is not registered.
+	EventUnknownTool EventType = "unknown_tool"
+)
+
+// Event is a structured record of something notable during a run.
+type Event struct {
+	Type     EventType
+	Step     int    // Step of the run the event belongs to
+	Function string // Function name as written by the model
+	Input    string // Function input as written by the model
+	Resolved string // Registered tool the call was resolved to, empty if none
+	Message  string // Human readable description
+}
+
+// WithEventHandler sets a callback that receives every event as it is recorded.
+func WithEventHandler(handler func(Event)) AgentOption {
+	return func(a *Agent) {
+		a.OnEvent = handler
+	}
+}
+
+// Events returns the events recorded during the current or last run.
+func (a *Agent) Events() []Event {
+	a.usageMu.Lock()
+	defer a.usageMu.Unlock()
+	events := make([]Event, len(a.events))
+	copy(events, a.events)
+	return events
+}
+
+func (a *Agent) recordEvent(event Event) {
+	a.usageMu.Lock()
+	event.Step = a.usage.Steps
+	a.events = append(a.events, event)
+	a.usageMu.Unlock()
+	if a.OnEvent != nil {
+		a.OnEvent(event)
+	}
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedURL is returned for page URLs that are not http or https,
+// such as file:// or chrome:// URLs.
+var ErrUnsupportedURL = errors.New("unsupported url, only http and https are supported")
+
+// Fetcher returns the readable content of a web page.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (string, error)
+}
+
+// FetcherFunc adapts an ordinary function to the Fetcher interface.
+type FetcherFunc func(ctx context.Context, url string) (string, error)
+
+// Fetch calls f(ctx, url).
+func (f FetcherFunc) Fetch(ctx context.Context, url string) (string, error) {
+	return f(ctx, url)
+}
+
+// WithFetcher makes the Browse tool use fetcher. It does not add Browse back
+// if it was removed; register NewBrowseTool(fetcher) for that.
+func WithFetcher(fetcher Fetcher) AgentOption {
+	return func(a *Agent) {
+		a.Tools.Replace(NewBrowseTool(fetcher))
+	}
+}
+
+// NewBrowseTool returns a Browse tool backed by fetcher.
+// Its results carry the page URL as Source so the agent digests them.
+func NewBrowseTool(fetcher Fetcher) Tool {
+	return &browseTool{fetcher: fetcher}
+}
+
+type browseTool struct {
+	fetcher Fetcher
+}
+
+func (t *browseTool) Name() string { return "Browse" }
+func (t *browseTool) Description() string {
+	return "This browse is useful when users want to get content of a page."
+}
+func (t *browseTool) Schema() json.RawMessage { return InputSchema("website url") }
+
+func (t *browseTool) Call(ctx context.Context, args json.RawMessage) (Result, error) {
+	input, err := StringArgument(args, "input")
+	if err != nil {
+		return Result{}, err
+	}
+	pageURL := strings.TrimSpace(input)
+	if err := checkPageURL(pageURL); err != nil {
+		return Result{}, err
+	}
+	content, err := t.fetcher.Fetch(ctx, pageURL)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Content: content, Source: pageURL}, nil
+}
+
+// DefaultFetcher returns the fetcher used by the Browse tool: a plain HTTP
+// fetcher that falls back to headless Chrome from pool for pages needing
+// JavaScript. A nil pool uses a pool shared by the package.
+func DefaultFetcher(pool *BrowserPool) Fetcher {
+	return &FallbackFetcher{
+		Primary:  &HTTPFetcher{},
+		Fallback: &ChromeFetcher{Pool: pool},
+	}
+}
+
+// HTTPFetcher fetches pages with a plain HTTP GET and converts the main
+// content to Markdown, without starting a browser.
+type HTTPFetcher struct {
+	Client    *http.Client // HTTP client, defaults to http.DefaultClient
+	UserAgent string       // User-Agent header, defaults to a generic browser string
+	MaxBytes  int64        // Maximum body size read, defaults to 5 MB
+}
+
+const defaultUserAgent = "Mozilla/5.0 (compatible; miniagent/1.0; +https://github.com/bgokden/miniagent)"
+
+// Fetch returns the page at pageURL as Markdown, headed by its title and URL.
+func (f *HTTPFetcher) Fetch(ctx context.Context, pageURL string) (string, error) {
+	if err := checkPageURL(pageURL); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	userAgent := f.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,text/plain;q=0.9,*/*;q=0.5")
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetching %s: status code %d", pageURL, resp.StatusCode)
+	}
+
+	maxBytes := f.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 5 << 20
+	}
+	body := io.LimitReader(resp.Body, maxBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "" || mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		title, markdown, err := ExtractMarkdown(body, resp.Request.URL)
+		if err != nil {
+			return "", err
+		}
+		return formatPage(title, resp.Request.URL.String(), markdown), nil
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json":
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		return formatPage("", resp.Request.URL.String(), strings.TrimSpace(string(data))), nil
+	}
+	return "", fmt.Errorf("fetching %s: unsupported content type %s", pageURL, mediaType)
+}
+
+// checkPageURL rejects page URLs that do not parse or are not http or https.
+func checkPageURL(pageURL string) error {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: %q", ErrUnsupportedURL, pageURL)
+	}
+	return nil
+}
+
+func formatPage(title, pageURL, content string) string {
+	var b strings.Builder
+	if title != "" {
+		b.WriteString(fmt.Sprintf("# %s\n\n", title))
+	}
+	b.WriteString(fmt.Sprintf("Source: %s\n\n", pageURL))
+	b.WriteString(content)
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ChromeFetcher renders pages in headless Chrome, for pages that need JavaScript.
+type ChromeFetcher struct {
+	Pool *BrowserPool // Browser pool to use, nil for the pool shared by the package
+}
+
+// Fetch returns the DOM tree of the rendered page.
+func (f *ChromeFetcher) Fetch(ctx context.Context, pageURL string) (string, error) {
+	if f.Pool != nil {
+		return f.Pool.Fetch(ctx, pageURL)
+	}
+	return browsePage(ctx, pageURL)
+}
+
+// FallbackFetcher uses Primary and switches to Fallback when Primary fails or
+// returns less than MinContentLength characters, as JavaScript rendered pages do.
+// URLs that Primary rejects with ErrUnsupportedURL are not passed to Fallback.
+type FallbackFetcher struct {
+	Primary          Fetcher
+	Fallback         Fetcher
+	MinContentLength int // Defaults to 500
+}
+
+// Fetch returns the content of pageURL from the first fetcher that succeeds.
+func (f *FallbackFetcher) Fetch(ctx context.Context, pageURL string) (string, error) {
+	minLength := f.MinContentLength
+	if minLength <= 0 {
+		minLength = 500
+	}
+	content, err := f.Primary.Fetch(ctx, pageURL)
+	if err == nil && utf8.RuneCountInString(strings.TrimSpace(content)) >= minLength {
+		return content, nil
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if errors.Is(err, ErrUnsupportedURL) {
+		return "", err
+	}
+	if err != nil {
+		log.Printf("Fetching %s failed, falling back: %v", pageURL, err)
+	} else {
+		log.Printf("Fetching %s returned little content, falling back", pageURL)
+	}
+	fallback, fallbackErr := f.Fallback.Fetch(ctx, pageURL)
+	if fallbackErr != nil {
+		if err == nil {
+			// Little content is better than none.
+			return content, nil
+		}
+		return "", fallbackErr
+	}
+	return fallback, nil
+}
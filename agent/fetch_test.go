@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const articlePage = `<!DOCTYPE html>
+<html><head><title>Gophers  in the wild</title><script>var tracking = 1;</script></head>
+<body>
+<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+<div class="cookie-banner">We use cookies.</div>
+<article>
+<h1>Gophers</h1>
+<p>Gophers are <strong>burrowing rodents</strong> that live in <a href="/north-america">North America</a>, digging long tunnel systems.</p>
+<ul><li>Pocket gophers</li><li>Ground squirrels, sometimes</li></ul>
+<pre>func dig() {
+	tunnel++
+}</pre>
+<table><tr><th>Name</th><th>Length</th></tr><tr><td>Pocket gopher</td><td>20 cm</td></tr></table>
+<p>They eat roots, tubers and plants, which makes them unpopular with gardeners everywhere in the region.</p>
+</article>
+<footer>Copyright</footer>
+</body></html>`
+
+func TestExtractMarkdown(t *testing.T) {
+	base, _ := url.Parse("https://example.com/animals/")
+	title, markdown, err := ExtractMarkdown(strings.NewReader(articlePage), base)
+	if err != nil {
+		t.Fatalf("ExtractMarkdown: %v", err)
+	}
+	if title != "Gophers in the wild" {
+		t.Errorf("title = %q", title)
+	}
+	want := "# Gophers\n\n" +
+		"Gophers are **burrowing rodents** that live in [North America](https://example.com/north-america), digging long tunnel systems.\n\n" +
+		"- Pocket gophers\n- Ground squirrels, sometimes\n\n" +
+		"```\nfunc dig() {\n\ttunnel++\n}\n```\n\n" +
+		"| Name | Length |\n| --- | --- |\n| Pocket gopher | 20 cm |\n\n" +
+		"They eat roots, tubers and plants, which makes them unpopular with gardeners everywhere in the region."
+	if markdown != want {
+		t.Errorf("markdown =\n%s\nwant\n%s", markdown, want)
+	}
+}
+
+func TestMainContentTiesGoToTheFirstCandidate(t *testing.T) {
+	paragraph := "<p>This paragraph is long enough to count, with a comma or two, for scoring.</p>"
+	page := `<html><body><div id="a"><div>` + paragraph + `</div></div><div id="b"><div>` + paragraph + `</div></div></body></html>`
+	for i := 0; i < 20; i++ {
+		_, markdown, err := ExtractMarkdown(strings.NewReader(strings.Replace(page, "This", "First", 1)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(markdown, "First") {
+			t.Fatalf("run %d picked the second candidate: %q", i, markdown)
+		}
+	}
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	var userAgent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, articlePage)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "  plain notes \n")
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	f := &HTTPFetcher{}
+	ctx := context.Background()
+
+	page, err := f.Fetch(ctx, server.URL+"/moved")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if !strings.HasPrefix(page, "# Gophers in the wild\n\nSource: "+server.URL+"/page\n\n# Gophers\n\n") {
+		t.Errorf("page does not start with its title and final URL:\n%s", page)
+	}
+	if strings.Contains(page, "cookies") || strings.Contains(page, "Copyright") || strings.Contains(page, "tracking") {
+		t.Errorf("page contains boilerplate:\n%s", page)
+	}
+	if userAgent != defaultUserAgent {
+		t.Errorf("User-Agent = %q", userAgent)
+	}
+
+	if text, err := f.Fetch(ctx, server.URL+"/notes.txt"); err != nil || text != "Source: "+server.URL+"/notes.txt\n\nplain notes\n" {
+		t.Errorf("plain text = %q, %v", text, err)
+	}
+	if _, err := f.Fetch(ctx, server.URL+"/image.png"); err == nil || !strings.Contains(err.Error(), "unsupported content type") {
+		t.Errorf("image error = %v", err)
+	}
+	if _, err := f.Fetch(ctx, server.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing page error = %v", err)
+	}
+	if _, err := f.Fetch(ctx, "file:///etc/passwd"); err == nil {
+		t.Error("file URL accepted")
+	}
+}
+
+// countingFetcher returns content or err and counts its calls.
+type countingFetcher struct {
+	content string
+	err     error
+	calls   int
+}
+
+func (f *countingFetcher) Fetch(ctx context.Context, url string) (string, error) {
+	f.calls++
+	return f.content, f.err
+}
+
+func TestFallbackFetcher(t *testing.T) {
+	long := strings.Repeat("a", 500)
+	tests := []struct {
+		name          string
+		primary       *countingFetcher
+		fallback      *countingFetcher
+		want          string
+		wantErr       bool
+		wantFallbacks int
+	}{
+		{name: "primary is enough", primary: &countingFetcher{content: long}, fallback: &countingFetcher{content: "rendered"}, want: long},
+		{name: "short content", primary: &countingFetcher{content: "loading..."}, fallback: &countingFetcher{content: "rendered"}, want: "rendered", wantFallbacks: 1},
+		{name: "primary fails", primary: &countingFetcher{err: errors.New("reset")}, fallback: &countingFetcher{content: "rendered"}, want: "rendered", wantFallbacks: 1},
+		{name: "short content beats a failed fallback", primary: &countingFetcher{content: "loading..."}, fallback: &countingFetcher{err: errors.New("no chrome")}, want: "loading...", wantFallbacks: 1},
+		{name: "both fail", primary: &countingFetcher{err: errors.New("reset")}, fallback: &countingFetcher{err: errors.New("no chrome")}, wantErr: true, wantFallbacks: 1},
+		// 200 characters are 600 bytes, still too short for a 300 character minimum.
+		{name: "length is counted in characters", primary: &countingFetcher{content: strings.Repeat("語", 200)}, fallback: &countingFetcher{content: "rendered"}, want: "rendered", wantFallbacks: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &FallbackFetcher{Primary: tt.primary, Fallback: tt.fallback}
+			if strings.HasPrefix(tt.name, "length") {
+				f.MinContentLength = 300
+			}
+			got, err := f.Fetch(context.Background(), "https://example.com")
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Fetch = %q, %v, want %q", got, err, tt.want)
+			}
+			if tt.fallback.calls != tt.wantFallbacks {
+				t.Errorf("fallback calls = %d, want %d", tt.fallback.calls, tt.wantFallbacks)
+			}
+		})
+	}
+}
+
+func TestBrowseToolSetsSource(t *testing.T) {
+	fetcher := &countingFetcher{content: "page"}
+	result, err := NewBrowseTool(fetcher).Call(context.Background(), json.RawMessage(`{"input":" https://example.com "}`))
+	if err != nil || result.Content != "page" || result.Source != "https://example.com" {
+		t.Errorf("Call = %+v, %v", result, err)
+	}
+}
+
+func TestUnsupportedURLsAreNotFetched(t *testing.T) {
+	fetcher := &countingFetcher{content: "root:x:0:0"}
+	for _, input := range []string{"file:///etc/passwd", "chrome://settings", "example.com"} {
+		args, _ := json.Marshal(map[string]string{"input": input})
+		if _, err := NewBrowseTool(fetcher).Call(context.Background(), args); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("Call(%q) error = %v, want ErrUnsupportedURL", input, err)
+		}
+	}
+	if fetcher.calls != 0 {
+		t.Errorf("fetcher called %d times", fetcher.calls)
+	}
+
+	// FallbackFetcher does not hand a rejected URL to Chrome.
+	fallback := &countingFetcher{content: "rendered"}
+	f := &FallbackFetcher{Primary: &HTTPFetcher{}, Fallback: fallback}
+	if _, err := f.Fetch(context.Background(), "file:///etc/passwd"); !errors.Is(err, ErrUnsupportedURL) || fallback.calls != 0 {
+		t.Errorf("Fetch error = %v after %d fallbacks", err, fallback.calls)
+	}
+}
+
+func TestWithFetcherKeepsBrowseRemoved(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithFetcher(&countingFetcher{}))
+	if _, ok := a.Tools.Get("Browse"); ok {
+		t.Error("WithFetcher added Browse back")
+	}
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/bgokden/miniagent/messages"
+	"github.com/bgokden/miniagent/renderer"
+)
+
+// LLMRequest is a single generation request sent to an LLM backend.
+type LLMRequest struct {
+	Stage    Stage              // Agent step issuing the request
+	Model    string             // Model name, empty for the backend's default
+	System   string             // System prompt
+	Prompt   string             // User prompt
+	Options  *GenerateOptions   // Generation parameters, nil for backend defaults
+	Messages []messages.Message // Conversation history, used by backends implementing MessageLLM
+	Format   string             // Response format, "json" constrains output to a JSON object where supported
+	Raw      bool               // Prompt is already formatted with the model's chat template and is sent as is
+	OnToken  func(token string) // Optional callback; when set the backend streams partial tokens to it
+}
+
+// LLM is the interface implemented by model backends used by the Agent.
+type LLM interface {
+	Generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error)
+}
+
+// MessageLLM is implemented by backends that send the conversation as
+// role-separated messages instead of a single flattened system prompt.
+// When UsesMessages returns true the Agent leaves the conversation out of
+// the system prompt and passes it in LLMRequest.Messages instead.
+type MessageLLM interface {
+	LLM
+	UsesMessages() bool
+}
+
+// RawLLM is implemented by backends that may not accept raw prompts, see
+// LLMRequest.Raw. Backends that do not implement it are assumed to accept them.
+type RawLLM interface {
+	LLM
+	SupportsRaw() bool
+}
+
+// ModelPuller is implemented by backends that can download models on demand.
+type ModelPuller interface {
+	PullModel(ctx context.Context, model string) error
+}
+
+// LLMFunc adapts an ordinary function to the LLM interface.
+// It is useful for middleware and for tests that do not need a live model.
+type LLMFunc func(ctx context.Context, req LLMRequest) (*GenerateResponse, error)
+
+// Generate calls f(ctx, req).
+func (f LLMFunc) Generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	return f(ctx, req)
+}
+
+func usesMessages(llm LLM) bool {
+	m, ok := llm.(MessageLLM)
+	return ok && m.UsesMessages()
+}
+
+func supportsRaw(llm LLM) bool {
+	r, ok := llm.(RawLLM)
+	return !ok || r.SupportsRaw()
+}
+
+// ChatMessage is a role-separated message as used by chat completion APIs.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// buildChatMessages converts a request into chat messages, see
+// renderer.ConversationWithToolRole. Function results are sent with toolRole
+// since chat protocols differ in whether they accept a dedicated tool role
+// without a tool call.
+func buildChatMessages(req LLMRequest, toolRole string) []ChatMessage {
+	conversation := renderer.ConversationWithToolRole(req.System, req.Messages, req.Prompt, renderer.Role(toolRole))
+	result := make([]ChatMessage, len(conversation))
+	for i, msg := range conversation {
+		result[i] = ChatMessage{Role: string(msg.Role), Content: msg.Content}
+	}
+	return result
+}
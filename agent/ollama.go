@@ -0,0 +1,365 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type GenerateRequest struct {
+	Model   string           `json:"model,omitempty"`
+	System  string           `json:"system,omitempty"`
+	Prompt  string           `json:"prompt,omitempty"`
+	Raw     bool             `json:"raw,omitempty"`
+	Stream  bool             `json:"stream"`
+	Format  string           `json:"format,omitempty"`
+	Options *GenerateOptions `json:"options,omitempty"`
+}
+
+type GenerateResponse struct {
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	PromptEvalCount int    `json:"prompt_eval_count,omitempty"` // Tokens in the prompt
+	EvalCount       int    `json:"eval_count,omitempty"`        // Tokens in the response
+}
+
+// ChatRequest is the request body of the Ollama /api/chat endpoint.
+type ChatRequest struct {
+	Model    string           `json:"model,omitempty"`
+	Messages []ChatMessage    `json:"messages"`
+	Stream   bool             `json:"stream"`
+	Format   string           `json:"format,omitempty"`
+	Options  *GenerateOptions `json:"options,omitempty"`
+}
+
+// ChatResponse is the response body of the Ollama /api/chat endpoint.
+type ChatResponse struct {
+	Message         ChatMessage `json:"message"`
+	Done            bool        `json:"done"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+	EvalCount       int         `json:"eval_count,omitempty"`
+}
+
+// Ollama is an LLM backend for an Ollama server.
+// By default it uses /api/generate; with Chat set it uses /api/chat and
+// sends the conversation as role-separated messages so the model's own
+// chat template is applied. Raw requests always use /api/generate.
+type Ollama struct {
+	Endpoint string       // Base URL of the Ollama server, defaults to $OLLAMA_ENDPOINT
+	Model    string       // Model name, defaults to MODEL_NAME
+	Client   *http.Client // HTTP client, defaults to http.DefaultClient
+	Chat     bool         // Use /api/chat instead of /api/generate
+
+	MaxRetries int // Retries after a gateway timeout (error code 524), defaults to 3, negative disables
+}
+
+const defaultMaxRetries = 3
+
+// retryBackoff is the delay before the first retry; it doubles on every attempt.
+var retryBackoff = time.Second
+
+// NewOllama creates an Ollama backend for the given endpoint and model.
+// Empty values fall back to $OLLAMA_ENDPOINT and MODEL_NAME at request time.
+func NewOllama(endpoint, model string) *Ollama {
+	return &Ollama{
+		Endpoint: endpoint,
+		Model:    model,
+	}
+}
+
+// NewOllamaChat creates an Ollama backend that uses the /api/chat endpoint.
+func NewOllamaChat(endpoint, model string) *Ollama {
+	return &Ollama{
+		Endpoint: endpoint,
+		Model:    model,
+		Chat:     true,
+	}
+}
+
+func (o *Ollama) endpoint() string {
+	if o.Endpoint != "" {
+		return o.Endpoint
+	}
+	return os.Getenv("OLLAMA_ENDPOINT")
+}
+
+func (o *Ollama) model(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if o.Model != "" {
+		return o.Model
+	}
+	return MODEL_NAME
+}
+
+func (o *Ollama) maxRetries() int {
+	if o.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	return o.MaxRetries
+}
+
+func (o *Ollama) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// UsesMessages reports whether the conversation is sent as chat messages.
+func (o *Ollama) UsesMessages() bool {
+	return o.Chat
+}
+
+// Generate sends the request to Ollama and returns the complete response.
+func (o *Ollama) Generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	var generateResp *GenerateResponse
+	var err error
+	if o.Chat && !req.Raw {
+		generateResp, err = o.chat(ctx, req)
+	} else {
+		generateResp, err = o.generate(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if LOG_MODEL {
+		log.Printf("\n------------\nSystem:\n%s\nPrompt:\n%s\nOutput:\n%s\n------------\n", req.System, req.Prompt, generateResp.Response)
+	}
+
+	return generateResp, nil
+}
+
+func (o *Ollama) generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	requestBody := &GenerateRequest{
+		Model:   o.model(req.Model),
+		System:  req.System,
+		Prompt:  req.Prompt,
+		Raw:     req.Raw,
+		Stream:  req.OnToken != nil,
+		Format:  req.Format,
+		Options: req.Options,
+	}
+
+	if requestBody.Stream {
+		var response strings.Builder
+		var last GenerateResponse
+		err := o.stream(ctx, "/api/generate", requestBody, func(line []byte) error {
+			last = GenerateResponse{}
+			if err := json.Unmarshal(line, &last); err != nil {
+				return err
+			}
+			if last.Response != "" {
+				response.WriteString(last.Response)
+				req.OnToken(last.Response)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		last.Response = response.String()
+		return &last, nil
+	}
+
+	var generateResp GenerateResponse
+	if err := o.post(ctx, "/api/generate", requestBody, &generateResp); err != nil {
+		return nil, err
+	}
+	return &generateResp, nil
+}
+
+func (o *Ollama) chat(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	requestBody := &ChatRequest{
+		Model:    o.model(req.Model),
+		Messages: buildChatMessages(req, "tool"),
+		Stream:   req.OnToken != nil,
+		Format:   req.Format,
+		Options:  req.Options,
+	}
+
+	if requestBody.Stream {
+		var response strings.Builder
+		var last ChatResponse
+		err := o.stream(ctx, "/api/chat", requestBody, func(line []byte) error {
+			last = ChatResponse{}
+			if err := json.Unmarshal(line, &last); err != nil {
+				return err
+			}
+			if last.Message.Content != "" {
+				response.WriteString(last.Message.Content)
+				req.OnToken(last.Message.Content)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &GenerateResponse{
+			Response:        response.String(),
+			Done:            last.Done,
+			PromptEvalCount: last.PromptEvalCount,
+			EvalCount:       last.EvalCount,
+		}, nil
+	}
+
+	var chatResp ChatResponse
+	if err := o.post(ctx, "/api/chat", requestBody, &chatResp); err != nil {
+		return nil, err
+	}
+	return &GenerateResponse{
+		Response:        chatResp.Message.Content,
+		Done:            chatResp.Done,
+		PromptEvalCount: chatResp.PromptEvalCount,
+		EvalCount:       chatResp.EvalCount,
+	}, nil
+}
+
+// PullModel downloads the given model, or the backend's default model if empty.
+func (o *Ollama) PullModel(ctx context.Context, model string) error {
+	requestBody := struct {
+		Name   string `json:"name"`
+		Stream bool   `json:"stream"`
+	}{
+		Name:   o.model(model),
+		Stream: false,
+	}
+	log.Printf("Pulling model %s from %s", requestBody.Name, o.endpoint())
+
+	var response struct {
+		Status string `json:"status"`
+	}
+	if err := o.post(ctx, "/api/pull", requestBody, &response); err != nil {
+		return err
+	}
+
+	log.Println(response)
+
+	if response.Status != "success" {
+		return fmt.Errorf("pulling model %s did not return success status", requestBody.Name)
+	}
+
+	return nil
+}
+
+// PullModel downloads MODEL_NAME from the Ollama server at $OLLAMA_ENDPOINT.
+//
+// Deprecated: Use Agent.PullModel, which pulls the models of every stage.
+func PullModel() error {
+	return NewOllama("", "").PullModel(context.Background(), "")
+}
+
+// gatewayTimeout is the whole reply body of a gateway that timed out waiting
+// for the model.
+const gatewayTimeout = "error code: 524"
+
+// post sends requestBody as JSON to the given API path and decodes the reply into out.
+// Gateway timeouts reported as "error code: 524" are retried.
+func (o *Ollama) post(ctx context.Context, path string, requestBody interface{}, out interface{}) error {
+	return o.send(ctx, path, requestBody, func(resp *http.Response, reply io.Reader) error {
+		body, err := io.ReadAll(reply)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("API Error code: %d", resp.StatusCode)
+		}
+
+		err = json.Unmarshal(body, out)
+		if err != nil {
+			log.Printf("error decoding response: %v", err)
+			if e, ok := err.(*json.SyntaxError); ok {
+				log.Printf("syntax error at byte offset %d", e.Offset)
+			}
+			log.Printf("Response: %q", body)
+			return err
+		}
+		return nil
+	})
+}
+
+// stream sends requestBody as JSON to the given API path and calls onLine for
+// every chunk of the newline delimited JSON reply.
+// Gateway timeouts are retried as in post.
+func (o *Ollama) stream(ctx context.Context, path string, requestBody interface{}, onLine func(line []byte) error) error {
+	return o.send(ctx, path, requestBody, func(resp *http.Response, reply io.Reader) error {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("API Error code: %d", resp.StatusCode)
+		}
+
+		scanner := bufio.NewScanner(reply)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var chunk struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(line, &chunk); err == nil && chunk.Error != "" {
+				return fmt.Errorf("API Error: %s", chunk.Error)
+			}
+			if err := onLine(line); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+// send posts requestBody as JSON to the given API path and passes the reply to
+// handle. Replies that are a gateway timeout are retried with exponential
+// backoff, up to maxRetries times.
+func (o *Ollama) send(ctx context.Context, path string, requestBody interface{}, handle func(resp *http.Response, reply io.Reader) error) error {
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, "POST", o.endpoint()+path, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return err
+		}
+
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := o.client().Do(httpReq)
+		if err != nil {
+			return err
+		}
+
+		// Peek one byte past the marker so that only a reply consisting of
+		// the marker alone counts as a timeout.
+		reply := bufio.NewReader(resp.Body)
+		if head, _ := reply.Peek(len(gatewayTimeout) + 1); string(head) != gatewayTimeout {
+			err = handle(resp, reply)
+			resp.Body.Close()
+			return err
+		}
+		resp.Body.Close()
+
+		if attempt >= o.maxRetries() {
+			return fmt.Errorf("API Error: gateway timeout after %d attempts", attempt+1)
+		}
+		delay := retryBackoff << attempt
+		log.Printf("Response: %q, retrying in %s", gatewayTimeout, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOllamaRetriesGatewayTimeouts(t *testing.T) {
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			fmt.Fprint(w, "error code: 524")
+			return
+		}
+		fmt.Fprint(w, `{"response":"ok","done":true}`)
+	}))
+	defer server.Close()
+
+	resp, err := NewOllama(server.URL, "m").Generate(context.Background(), LLMRequest{Prompt: "hi"})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "ok" || calls != 3 {
+		t.Errorf("response = %q after %d calls, want ok after 3", resp.Response, calls)
+	}
+}
+
+func TestOllamaGivesUpOnGatewayTimeouts(t *testing.T) {
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, "error code: 524")
+	}))
+	defer server.Close()
+
+	o := NewOllama(server.URL, "m")
+	o.MaxRetries = 2
+	if _, err := o.Generate(context.Background(), LLMRequest{Prompt: "hi"}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+
+	calls = 0
+	o.MaxRetries = -1
+	if _, err := o.Generate(context.Background(), LLMRequest{Prompt: "hi"}); err == nil || calls != 1 {
+		t.Errorf("with retries disabled: err = %v after %d calls", err, calls)
+	}
+}
+
+func TestOllamaRetryStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "error code: 524")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := NewOllama(server.URL, "m").Generate(ctx, LLMRequest{Prompt: "hi"}); err != context.DeadlineExceeded {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > retryBackoff {
+		t.Errorf("returned after %s, want before the first backoff ends", elapsed)
+	}
+}
+
+func TestOllamaStreamsGenerate(t *testing.T) {
+	var stream bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.URL.Path != "/api/generate" {
+			t.Errorf("request %s: %v", r.URL.Path, err)
+		}
+		stream = req.Stream
+		fmt.Fprintln(w, `{"response":"Go","done":false}`)
+		fmt.Fprintln(w, `{"response":"phers","done":false}`)
+		fmt.Fprintln(w, `{"response":"","done":true,"prompt_eval_count":12,"eval_count":2}`)
+	}))
+	defer server.Close()
+
+	var tokens []string
+	resp, err := NewOllama(server.URL, "m").Generate(context.Background(), LLMRequest{
+		Prompt:  "hi",
+		OnToken: func(token string) { tokens = append(tokens, token) },
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if !stream {
+		t.Error("the request did not ask for a stream")
+	}
+	if strings.Join(tokens, "|") != "Go|phers" {
+		t.Errorf("tokens = %q", tokens)
+	}
+	if resp.Response != "Gophers" || !resp.Done || resp.PromptEvalCount != 12 || resp.EvalCount != 2 {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestOllamaRetriesStreamedGatewayTimeouts(t *testing.T) {
+	defer func(d time.Duration) { retryBackoff = d }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			fmt.Fprint(w, "error code: 524")
+			return
+		}
+		fmt.Fprintln(w, `{"response":"ok","done":true}`)
+	}))
+	defer server.Close()
+
+	var tokens []string
+	resp, err := NewOllama(server.URL, "m").Generate(context.Background(), LLMRequest{
+		Prompt:  "hi",
+		OnToken: func(token string) { tokens = append(tokens, token) },
+	})
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "ok" || len(tokens) != 1 || calls != 2 {
+		t.Errorf("response = %q, tokens = %q after %d calls", resp.Response, tokens, calls)
+	}
+}
@@ -0,0 +1,246 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ChatCompletionRequest is the request body of a /v1/chat/completions call.
+type ChatCompletionRequest struct {
+	Model       string        `json:"model,omitempty"`
+	Messages    []ChatMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	TopP        *float64      `json:"top_p,omitempty"`
+	MaxTokens   *int          `json:"max_tokens,omitempty"`
+	Seed        *int          `json:"seed,omitempty"`
+	Stop        []string      `json:"stop,omitempty"`
+
+	ResponseFormat *ChatResponseFormat `json:"response_format,omitempty"`
+	StreamOptions  *ChatStreamOptions  `json:"stream_options,omitempty"`
+}
+
+// ChatStreamOptions configures a streamed chat completion.
+type ChatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // Send token usage in a final chunk
+}
+
+// ChatResponseFormat constrains the output of a chat completion.
+type ChatResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// ChatCompletionResponse is the response of a /v1/chat/completions call.
+type ChatCompletionResponse struct {
+	Choices []struct {
+		Message      ChatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage ChatUsage `json:"usage"`
+}
+
+// ChatUsage reports the tokens used by a chat completion.
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// OpenAI is an LLM backend for servers exposing the OpenAI compatible
+// /v1/chat/completions protocol, e.g. llama.cpp server, vLLM and LocalAI.
+type OpenAI struct {
+	BaseURL string       // Base URL including the /v1 prefix, defaults to $OPENAI_BASE_URL
+	APIKey  string       // Optional bearer token, defaults to $OPENAI_API_KEY
+	Model   string       // Model name, defaults to MODEL_NAME
+	Client  *http.Client // HTTP client, defaults to http.DefaultClient
+}
+
+// NewOpenAI creates an OpenAI compatible backend for the given base URL and model.
+func NewOpenAI(baseURL, model string) *OpenAI {
+	return &OpenAI{
+		BaseURL: baseURL,
+		Model:   model,
+	}
+}
+
+func (o *OpenAI) baseURL() string {
+	if o.BaseURL != "" {
+		return strings.TrimSuffix(o.BaseURL, "/")
+	}
+	return strings.TrimSuffix(os.Getenv("OPENAI_BASE_URL"), "/")
+}
+
+func (o *OpenAI) apiKey() string {
+	if o.APIKey != "" {
+		return o.APIKey
+	}
+	return os.Getenv("OPENAI_API_KEY")
+}
+
+func (o *OpenAI) model(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	if o.Model != "" {
+		return o.Model
+	}
+	return MODEL_NAME
+}
+
+func (o *OpenAI) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// UsesMessages reports that the conversation is sent as chat messages.
+func (o *OpenAI) UsesMessages() bool {
+	return true
+}
+
+// SupportsRaw reports that raw prompts are rejected, since the chat
+// completions protocol applies the model's template on the server.
+func (o *OpenAI) SupportsRaw() bool {
+	return false
+}
+
+// Generate sends the request to /chat/completions and returns the first choice.
+// Function results are sent as user messages since a tool role requires a
+// matching tool call id in the OpenAI protocol.
+func (o *OpenAI) Generate(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+	if req.Raw {
+		return nil, fmt.Errorf("raw prompts are not supported by the chat completions protocol")
+	}
+	completionsEndpoint := fmt.Sprintf("%s/chat/completions", o.baseURL())
+
+	requestBody := &ChatCompletionRequest{
+		Model:    o.model(req.Model),
+		Messages: buildChatMessages(req, "user"),
+		Stream:   req.OnToken != nil,
+	}
+	if requestBody.Stream {
+		requestBody.StreamOptions = &ChatStreamOptions{IncludeUsage: true}
+	}
+	if req.Format == "json" {
+		requestBody.ResponseFormat = &ChatResponseFormat{Type: "json_object"}
+	}
+	if opts := req.Options; opts != nil {
+		// num_ctx, top_k and repeat_penalty have no equivalent in the protocol.
+		requestBody.Temperature = opts.Temperature
+		requestBody.TopP = opts.TopP
+		requestBody.MaxTokens = opts.NumPredict
+		requestBody.Seed = opts.Seed
+		requestBody.Stop = opts.Stop
+	}
+
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", completionsEndpoint, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if apiKey := o.apiKey(); apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := o.client().Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 && req.OnToken != nil {
+		return o.readStream(resp.Body, req)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("API Error code: %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var completionResp ChatCompletionResponse
+	if err := json.Unmarshal(body, &completionResp); err != nil {
+		log.Printf("Response: %q", body)
+		return nil, err
+	}
+	if len(completionResp.Choices) == 0 {
+		return nil, fmt.Errorf("chat completion returned no choices")
+	}
+
+	generateResp := &GenerateResponse{
+		Response:        completionResp.Choices[0].Message.Content,
+		Done:            true,
+		PromptEvalCount: completionResp.Usage.PromptTokens,
+		EvalCount:       completionResp.Usage.CompletionTokens,
+	}
+
+	if LOG_MODEL {
+		log.Printf("\n------------\nSystem:\n%s\nPrompt:\n%s\nOutput:\n%s\n------------\n", req.System, req.Prompt, generateResp.Response)
+	}
+
+	return generateResp, nil
+}
+
+// readStream reads a server-sent events reply, passing every content delta
+// to req.OnToken and returning the assembled response with the usage of the
+// final chunk.
+func (o *OpenAI) readStream(body io.Reader, req LLMRequest) (*GenerateResponse, error) {
+	var response strings.Builder
+	var usage ChatUsage
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta ChatMessage `json:"delta"`
+			} `json:"choices"`
+			Usage *ChatUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, err
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				response.WriteString(choice.Delta.Content)
+				req.OnToken(choice.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &GenerateResponse{
+		Response:        response.String(),
+		Done:            true,
+		PromptEvalCount: usage.PromptTokens,
+		EvalCount:       usage.CompletionTokens,
+	}, nil
+}
@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+)
+
+// chatRequest is a request with every kind of history message.
+func chatRequest() LLMRequest {
+	return LLMRequest{
+		System: "be brief",
+		Messages: []messages.Message{
+			{Type: messages.HumanMessage, Sender: "Human", Content: "find gophers"},
+			{Type: messages.AIMessage, Sender: "AI", Content: "searching"},
+			{Type: messages.FunctionResult, Sender: "System", FunctionName: "Search", Content: "gophers dig"},
+		},
+		Prompt: "continue",
+	}
+}
+
+func TestOpenAIGenerate(t *testing.T) {
+	var got ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"hi"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+	}))
+	defer server.Close()
+
+	o := &OpenAI{BaseURL: server.URL + "/v1/", APIKey: "secret", Model: "m"}
+	req := chatRequest()
+	req.Format = "json"
+	resp, err := o.Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "hi" || resp.PromptEvalCount != 12 || resp.EvalCount != 3 {
+		t.Errorf("response = %+v", resp)
+	}
+	if got.Model != "m" || got.Stream {
+		t.Errorf("model = %q, stream = %v", got.Model, got.Stream)
+	}
+	if got.ResponseFormat == nil || got.ResponseFormat.Type != "json_object" {
+		t.Errorf("response_format = %+v, want json_object", got.ResponseFormat)
+	}
+	want := []ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "find gophers"},
+		{Role: "assistant", Content: "searching"},
+		{Role: "user", Content: "[Search] gophers dig"},
+		{Role: "user", Content: "continue"},
+	}
+	if fmt.Sprint(got.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %+v, want %+v", got.Messages, want)
+	}
+}
+
+func TestOpenAIStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("stream = %v, stream_options = %+v, want streamed usage", req.Stream, req.StreamOptions)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	var tokens []string
+	req := chatRequest()
+	req.OnToken = func(token string) { tokens = append(tokens, token) }
+	resp, err := NewOpenAI(server.URL, "m").Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "Hello" || resp.PromptEvalCount != 12 || resp.EvalCount != 2 {
+		t.Errorf("response = %+v, want Hello with 12+2 tokens", resp)
+	}
+	if strings.Join(tokens, "|") != "Hel|lo" {
+		t.Errorf("tokens = %q", tokens)
+	}
+}
+
+func TestOpenAIErrorStatus(t *testing.T) {
+	for _, stream := range []bool{false, true} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		}))
+		req := chatRequest()
+		if stream {
+			req.OnToken = func(string) { t.Error("token received from an error reply") }
+		}
+		_, err := NewOpenAI(server.URL, "m").Generate(context.Background(), req)
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "model not loaded") {
+			t.Errorf("stream=%v: error = %v, want status and body", stream, err)
+		}
+	}
+}
+
+func TestOpenAIRejectsRawPrompts(t *testing.T) {
+	_, err := NewOpenAI("http://127.0.0.1:0", "m").Generate(context.Background(), LLMRequest{Prompt: "x", Raw: true})
+	if err == nil {
+		t.Fatal("expected an error for a raw prompt")
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	var got ChatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"hi"},"done":true,"prompt_eval_count":7,"eval_count":2}`)
+	}))
+	defer server.Close()
+
+	resp, err := NewOllamaChat(server.URL, "m").Generate(context.Background(), chatRequest())
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "hi" || resp.PromptEvalCount != 7 || resp.EvalCount != 2 {
+		t.Errorf("response = %+v", resp)
+	}
+	want := []ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "find gophers"},
+		{Role: "assistant", Content: "searching"},
+		{Role: "tool", Content: "[Search] gophers dig"},
+		{Role: "user", Content: "continue"},
+	}
+	if fmt.Sprint(got.Messages) != fmt.Sprint(want) {
+		t.Errorf("messages = %+v, want %+v", got.Messages, want)
+	}
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"lo"},"done":true,"prompt_eval_count":7,"eval_count":2}`)
+	}))
+	defer server.Close()
+
+	var tokens []string
+	req := chatRequest()
+	req.OnToken = func(token string) { tokens = append(tokens, token) }
+	resp, err := NewOllamaChat(server.URL, "m").Generate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if resp.Response != "Hello" || resp.EvalCount != 2 || strings.Join(tokens, "|") != "Hel|lo" {
+		t.Errorf("response = %+v, tokens = %q", resp, tokens)
+	}
+}
+
+func TestOllamaErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if _, err := NewOllamaChat(server.URL, "m").Generate(context.Background(), chatRequest()); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("error = %v, want status 500", err)
+	}
+}
+
+// chatLLM is a fakeLLM that takes role-separated messages.
+type chatLLM struct{ fakeLLM }
+
+func (c *chatLLM) UsesMessages() bool { return true }
+
+func TestChatRequestsSendTheTaskOnce(t *testing.T) {
+	llm := &chatLLM{fakeLLM{replies: []string{
+		"Function: Lookup\nInput: gophers",
+		"Function: Finish\nInput: done",
+	}}}
+	lookup := NewTool("Lookup", "Looks things up", "query", func(ctx context.Context, input string) (string, error) {
+		return "Gophers live underground.", nil
+	})
+	a := newTestAgent(llm, WithTools(lookup))
+	if _, err := a.Run("Tell me about gophers"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	for i, req := range llm.stageRequests(StageAction) {
+		msgs := buildChatMessages(req, "tool")
+		count := 0
+		for _, msg := range msgs {
+			if msg.Role == "user" && strings.Contains(msg.Content, "gophers") {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Errorf("action request %d sends the task %d times: %+v", i, count, msgs)
+		}
+	}
+}
+
+func TestChatRequestsSendTheInferredTask(t *testing.T) {
+	llm := &chatLLM{fakeLLM{
+		inferred: "List three facts about gophers as bullet points.",
+		replies:  []string{"Function: Finish\nInput: done"},
+	}}
+	a := newTestAgent(llm)
+	if _, err := a.Run("gophers?"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	msgs := buildChatMessages(llm.stageRequests(StageAction)[0], "tool")
+	last := msgs[len(msgs)-1]
+	if last.Role != "user" || last.Content != llm.inferred {
+		t.Errorf("last message = %+v, want the inferred task", last)
+	}
+	if msgs[len(msgs)-2].Content != "gophers?" {
+		t.Errorf("messages = %+v, want the original input before the inferred task", msgs)
+	}
+}
@@ -0,0 +1,76 @@
+package agent
+
+// Stage identifies a step of the agent that calls the model.
+type Stage string
+
+const (
+	StageInferPrompt Stage = "infer_prompt" // Reformulating the user's intent
+	StageAction      Stage = "action"       // Choosing the next function
+	StageSummarize   Stage = "summarize"    // Summarizing web pages
+)
+
+// Stages lists every stage that calls the model.
+var Stages = []Stage{StageInferPrompt, StageAction, StageSummarize}
+
+// GenerateOptions are model generation parameters.
+// Nil fields are left to the backend's defaults.
+type GenerateOptions struct {
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	NumCtx        *int     `json:"num_ctx,omitempty"`
+	NumPredict    *int     `json:"num_predict,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+}
+
+// Float returns a pointer to v, for use in GenerateOptions.
+func Float(v float64) *float64 {
+	return &v
+}
+
+// Int returns a pointer to v, for use in GenerateOptions.
+func Int(v int) *int {
+	return &v
+}
+
+// Merge returns a copy of o with every field set in override replacing its value.
+// Either side may be nil.
+func (o *GenerateOptions) Merge(override *GenerateOptions) *GenerateOptions {
+	if o == nil && override == nil {
+		return nil
+	}
+	merged := &GenerateOptions{}
+	if o != nil {
+		*merged = *o
+	}
+	if override == nil {
+		return merged
+	}
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		merged.TopK = override.TopK
+	}
+	if override.NumCtx != nil {
+		merged.NumCtx = override.NumCtx
+	}
+	if override.NumPredict != nil {
+		merged.NumPredict = override.NumPredict
+	}
+	if override.RepeatPenalty != nil {
+		merged.RepeatPenalty = override.RepeatPenalty
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	if override.Stop != nil {
+		merged.Stop = override.Stop
+	}
+	return merged
+}
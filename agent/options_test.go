@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMergeOverridesSetFields(t *testing.T) {
+	base := &GenerateOptions{Temperature: Float(0.7), NumCtx: Int(4096), Stop: []string{"\n"}}
+	merged := base.Merge(&GenerateOptions{Temperature: Float(0), Seed: Int(1)})
+
+	want := &GenerateOptions{Temperature: Float(0), NumCtx: Int(4096), Seed: Int(1), Stop: []string{"\n"}}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("merged = %+v, want %+v", merged, want)
+	}
+	if *base.Temperature != 0.7 || base.Seed != nil {
+		t.Errorf("Merge modified its receiver: %+v", base)
+	}
+}
+
+func TestMergeNil(t *testing.T) {
+	var none *GenerateOptions
+	if none.Merge(nil) != nil {
+		t.Error("nil.Merge(nil) != nil")
+	}
+	opts := &GenerateOptions{TopK: Int(40)}
+	if got := none.Merge(opts); got == opts || !reflect.DeepEqual(got, opts) {
+		t.Errorf("nil.Merge(opts) = %+v, want a copy of %+v", got, opts)
+	}
+	if got := opts.Merge(nil); got == opts || !reflect.DeepEqual(got, opts) {
+		t.Errorf("opts.Merge(nil) = %+v, want a copy of %+v", got, opts)
+	}
+}
+
+func TestStageOptionsApplyToTheirStage(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"Function: Finish\nInput: done"}}
+	a := newTestAgent(llm,
+		WithOptions(&GenerateOptions{Temperature: Float(0.7), NumCtx: Int(4096)}),
+		WithStageOptions(StageAction, &GenerateOptions{Temperature: Float(0)}),
+	)
+	if _, err := a.Run("hello"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	action := llm.stageRequests(StageAction)[0].Options
+	if *action.Temperature != 0 || *action.NumCtx != 4096 {
+		t.Errorf("action options = %+v, want temperature 0 and num_ctx 4096", action)
+	}
+	infer := llm.stageRequests(StageInferPrompt)[0].Options
+	if *infer.Temperature != 0.7 {
+		t.Errorf("infer options = %+v, want temperature 0.7", infer)
+	}
+}
+
+func TestRequestOptionsOverrideStageOptions(t *testing.T) {
+	var got *GenerateOptions
+	a := newTestAgent(LLMFunc(func(ctx context.Context, req LLMRequest) (*GenerateResponse, error) {
+		got = req.Options
+		return &GenerateResponse{}, nil
+	}), WithStageOptions(StageSummarize, &GenerateOptions{NumPredict: Int(100)}))
+
+	a.generate(context.Background(), StageSummarize, LLMRequest{Options: &GenerateOptions{NumPredict: Int(10)}})
+	if got == nil || *got.NumPredict != 10 {
+		t.Errorf("options = %+v, want num_predict 10", got)
+	}
+}
+
+// pullLLM records the models it is asked to pull.
+type pullLLM struct {
+	fakeLLM
+	pulled []string
+}
+
+func (p *pullLLM) PullModel(ctx context.Context, model string) error {
+	p.pulled = append(p.pulled, model)
+	return nil
+}
+
+func TestPullModelOnlyPullsStageModels(t *testing.T) {
+	llm := &pullLLM{}
+	a := newTestAgent(llm,
+		WithModel("default"),
+		WithStageModel(StageInferPrompt, "small"),
+		WithStageModel(StageAction, "large"),
+		WithStageModel(StageSummarize, "small"),
+	)
+	if err := a.PullModel(); err != nil {
+		t.Fatalf("PullModel: %v", err)
+	}
+	if want := []string{"small", "large"}; !reflect.DeepEqual(llm.pulled, want) {
+		t.Errorf("pulled %q, want %q", llm.pulled, want)
+	}
+}
+
+func TestModelsFallBackToTheAgentModel(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithModel("default"), WithStageModel(StageAction, "large"))
+	if got, want := a.Models(), []string{"default", "large"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Models() = %q, want %q", got, want)
+	}
+}
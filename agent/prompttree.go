@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bgokden/miniagent/prompt"
+)
+
+// PromptData is available to the templates of a prompt tree as .Data.
+// Its values are computed only when a template uses them.
+type PromptData struct {
+	agent *Agent
+}
+
+// Model returns the model used to choose the next action.
+func (d PromptData) Model() string {
+	return d.agent.modelFor(StageAction)
+}
+
+// ActionFormat returns "text" or "json".
+func (d PromptData) ActionFormat() string {
+	if d.agent.ActionFormat == ActionFormatJSON {
+		return "json"
+	}
+	return "text"
+}
+
+// OutputFormat returns the instructions describing the expected action format.
+func (d PromptData) OutputFormat() string {
+	return d.agent.outputFormat()
+}
+
+// Tools returns the registered functions as rendered for the prompt.
+func (d PromptData) Tools() string {
+	return ToolsAsString(d.agent.Tools.List())
+}
+
+// History returns the whole conversation, one message per line.
+func (d PromptData) History() string {
+	return d.agent.MessageHistory.GetAllMessagesAsString()
+}
+
+// Time returns the current time, as returned by GetCurrentTimeString.
+func (d PromptData) Time() string {
+	return GetCurrentTimeString()
+}
+
+// WithPromptTreeFile loads the prompt tree from a YAML or JSON file, see
+// prompt.LoadTree. Its nodes can use the generators returned by
+// Agent.Generators and templates over PromptData. Parts with the summarize
+// truncation are shortened by the model of StageSummarize.
+func WithPromptTreeFile(path string) AgentOption {
+	return func(a *Agent) {
+		tree, err := prompt.LoadTree(path)
+		if err != nil {
+			if a.configErr == nil {
+				a.configErr = err
+			}
+			return
+		}
+		a.PromptTree = tree
+	}
+}
+
+// Generators returns the named generators available to prompt tree files:
+//
+//	system   role description and the action output format
+//	history  the conversation, dropping the oldest messages to fit
+//	tools    the registered functions
+//	ltm      long-term memory, currently empty
+func (a *Agent) Generators() map[string]prompt.Generator {
+	return map[string]prompt.Generator{
+		"system":  a.systemPart,
+		"history": a.historyPart,
+		"tools":   a.toolsPart,
+		"ltm":     a.ltmPart,
+	}
+}
+
+// summarizer shortens the parts of prompt tree nodes with
+// prompt.TruncateSummarize using the summarize stage model.
+func (a *Agent) summarizer(ctx context.Context) prompt.Summarizer {
+	return func(text string, maxTokens int) (string, error) {
+		system := fmt.Sprintf(`Summarize the following text in at most %d tokens.
+Keep the facts, names, numbers and links a later step may need and leave out everything else.
+Reply with the summary only.
+
+Text as input:`, maxTokens)
+		response, err := a.generate(ctx, StageSummarize, LLMRequest{System: system, Prompt: text})
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(response.Response), nil
+	}
+}
+
+// promptData returns the values available to prompt tree templates.
+func (a *Agent) promptData() PromptData {
+	return PromptData{agent: a}
+}
+
+// outputFormat describes the format the model should answer in.
+func (a *Agent) outputFormat() string {
+	if a.ActionFormat == ActionFormatJSON {
+		return "Output should only include one function as the next step as a single JSON object using the format:\n" +
+			"OUTPUT_FORMAT:\n" +
+			jsonActionFormat +
+			"END_OF_OUTPUT_FORMAT:\n"
+	}
+	return "Output should only include one function as the next step using the format:\n" +
+		"OUTPUT_FORMAT:\n" +
+		"Function: name of the function\n" +
+		"Input: Function Input as text\n" +
+		"Reasoning: Reason to choose the Function and Input\n" +
+		"Critism: Self critic of the current action\n" +
+		"END_OF_OUTPUT_FORMAT:\n"
+}
+
+func (a *Agent) systemPart(input string, maxLength int) (string, error) {
+	content := "You are an AI Assistant.\n" +
+		"This is a friendly conversation between Human and AI.\n" +
+		"Your primary role is to answer questions and provide assistance.\n" +
+		a.outputFormat() +
+		"Please only use the given functions in your responses.\n" +
+		"Please write only one function in one response.\n"
+	return content, nil
+}
+
+func (a *Agent) ltmPart(input string, maxLength int) (string, error) {
+	return "", nil
+}
+
+func (a *Agent) historyPart(input string, maxLength int) (string, error) {
+	if a.sendsMessages() {
+		// The conversation is sent as separate chat messages.
+		return "", nil
+	}
+	history, err := a.recentHistory(maxLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Conversation:\n%s\n", history), nil
+}
+
+func (a *Agent) toolsPart(input string, maxLength int) (string, error) {
+	return ToolsAsString(a.Tools.List()), nil
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+	"github.com/bgokden/miniagent/prompt"
+)
+
+func TestSummarizeTruncationUsesTheSummarizeStage(t *testing.T) {
+	llm := &fakeLLM{}
+	notes := prompt.NewFunctionNode("notes", 1, func(string, int) (string, error) {
+		return strings.Repeat("note ", 200), nil
+	})
+	notes.MaxTokens = 20
+	notes.Truncation = prompt.TruncateSummarize
+	a := newTestAgent(llm, WithPromptTree(prompt.NewFunctionNode("root", 0, nil, notes)), WithStageModel(StageSummarize, "small"))
+
+	system, _, err := a.GeneratePrompt("task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := llm.stageRequests(StageSummarize)
+	if len(reqs) != 1 {
+		t.Fatalf("summarize requests = %d, want 1", len(reqs))
+	}
+	if reqs[0].Model != "small" || !strings.Contains(reqs[0].System, "at most 20 tokens") || reqs[0].Prompt != strings.Repeat("note ", 200) {
+		t.Errorf("summarize request = %+v", reqs[0])
+	}
+	if n, _ := a.tokenCounter().CountTokens(system); n == 0 || n > 20 {
+		t.Errorf("summarized part has %d tokens, want 1 to 20", n)
+	}
+}
+
+func TestDefaultTreeFileMatchesTheBuiltInTree(t *testing.T) {
+	build := func(options ...AgentOption) string {
+		a := newTestAgent(&fakeLLM{}, options...)
+		a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", "Tell me about gophers")
+		system, _, err := a.GeneratePrompt("Tell me about gophers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return system
+	}
+	builtIn := build()
+	fromFile := build(WithPromptTreeFile(filepath.Join("..", "prompts", "default.yaml")))
+	if fromFile != builtIn {
+		t.Errorf("default.yaml renders\n%s\nthe built-in tree renders\n%s", fromFile, builtIn)
+	}
+	if !strings.Contains(builtIn, "You are an AI Assistant.") || !strings.Contains(builtIn, "Human: Tell me about gophers") {
+		t.Errorf("prompt misses the system part or the conversation:\n%s", builtIn)
+	}
+}
+
+func TestPromptTreeTemplatesUsePromptData(t *testing.T) {
+	root, err := prompt.ParseTree([]byte(`id: root
+template: "{{.Data.Model}} {{.Data.ActionFormat}}\n{{.Data.Tools}}{{.Data.History}}"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAgent(&fakeLLM{}, WithPromptTree(root), WithModel("tiny"), WithActionFormat(ActionFormatJSON))
+	a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", "hi")
+	system, _, err := a.GeneratePrompt("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "tiny json\n" + ToolsAsString(a.Tools.List()) + "Human: hi\n"
+	if system != want {
+		t.Errorf("prompt = %q, want %q", system, want)
+	}
+}
+
+func TestWithPromptTreeFileReportsLoadErrors(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithPromptTreeFile(filepath.Join(t.TempDir(), "missing.yaml")))
+	if _, err := a.Run("task"); err == nil {
+		t.Error("Run ignored the missing prompt tree file")
+	}
+}
+
+func TestExplainPromptMatchesGeneratePrompt(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithMaxLength(700))
+	a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", "Tell me about gophers")
+
+	report, err := a.ExplainPrompt("Tell me about gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	system, _, err := a.GeneratePrompt("Tell me about gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Prompt != system {
+		t.Errorf("ExplainPrompt prompt differs from GeneratePrompt:\n%s\n---\n%s", report.Prompt, system)
+	}
+	statuses := map[string]string{}
+	for _, node := range report.Nodes {
+		statuses[node.ID] = node.Status()
+	}
+	want := map[string]string{"root": "group", "system": "ok", "ltm": "empty", "stm": "ok", "functions": "ok"}
+	for id, status := range want {
+		if statuses[id] != status {
+			t.Errorf("node %s is %s, want %s", id, statuses[id], status)
+		}
+	}
+	if report.Tokens > 700 {
+		t.Errorf("prompt has %d tokens, more than 700", report.Tokens)
+	}
+}
+
+func TestExplainPromptReportsConfigErrors(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithToolRegistry(nil))
+	if _, err := a.ExplainPrompt("task"); err == nil {
+		t.Error("ExplainPrompt ignored the configuration error")
+	}
+}
@@ -0,0 +1,38 @@
+package agent
+
+import "github.com/bgokden/miniagent/renderer"
+
+// WithChatTemplate makes the agent format every request itself with t and
+// send it as a raw prompt, bypassing the template of the backend. The
+// conversation is rendered as separate turns and the stop sequences of t are
+// added to the generation options. See renderer.ForModel to pick the
+// template of a model. Backends that reject raw prompts, such as OpenAI,
+// cannot be combined with a chat template; Run then fails with an error.
+func WithChatTemplate(t renderer.Template) AgentOption {
+	return func(a *Agent) {
+		a.ChatTemplate = t
+	}
+}
+
+// sendsMessages reports whether the conversation is passed as messages
+// rather than rendered into the system prompt.
+func (a *Agent) sendsMessages() bool {
+	return a.ChatTemplate != nil || usesMessages(a.LLM)
+}
+
+// rawRequest renders req with the agent's chat template.
+func (a *Agent) rawRequest(req LLMRequest) LLMRequest {
+	conversation := renderer.Conversation(req.System, req.Messages, req.Prompt)
+	var stop []string
+	if req.Options != nil {
+		stop = append(stop, req.Options.Stop...)
+	}
+	stop = append(stop, a.ChatTemplate.Stop()...)
+
+	req.Prompt = a.ChatTemplate.Render(conversation)
+	req.System = ""
+	req.Messages = nil
+	req.Raw = true
+	req.Options = req.Options.Merge(&GenerateOptions{Stop: stop})
+	return req
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+	"github.com/bgokden/miniagent/renderer"
+)
+
+func TestRawRequestsUseTheChatTemplate(t *testing.T) {
+	llm := &fakeLLM{inferred: "Describe gophers.", replies: []string{"Function: Finish\nInput: done"}}
+	a := newTestAgent(llm, WithChatTemplate(renderer.ChatML), WithStageOptions(StageAction, &GenerateOptions{Stop: []string{"END"}}))
+	if _, err := a.Run("Tell me about gophers"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	req := llm.stageRequests(StageAction)[0]
+	if !req.Raw || req.System != "" || req.Messages != nil {
+		t.Errorf("request is not raw: %+v", req)
+	}
+	if !strings.HasPrefix(req.Prompt, "<|im_start|>system\nYou are an AI Assistant.") || !strings.HasSuffix(req.Prompt, "<|im_start|>assistant\n") {
+		t.Errorf("prompt is not rendered with ChatML:\n%s", req.Prompt)
+	}
+	if !strings.Contains(req.Prompt, "<|im_start|>user\nTell me about gophers<|im_end|>\n<|im_start|>user\nDescribe gophers.<|im_end|>\n") {
+		t.Errorf("the input and the inferred task are not the last user turns:\n%s", req.Prompt)
+	}
+	if stop := req.Options.Stop; len(stop) != 2 || stop[0] != "END" || stop[1] != "<|im_end|>" {
+		t.Errorf("stop = %q, want the stage stop and the template stop", stop)
+	}
+}
+
+func TestRawRequestsFitMaxLength(t *testing.T) {
+	for _, tmpl := range []renderer.Template{renderer.Llama2, renderer.Llama3} {
+		a := newTestAgent(&fakeLLM{}, WithChatTemplate(tmpl), WithMaxLength(80))
+		a.MessageHistory.AddMessage(messages.HumanMessage, "Human", "", "Tell me about gophers")
+		for i := 0; i < 30; i++ {
+			a.MessageHistory.AddMessage(messages.FunctionResult, "System", "Lookup", "Gophers dig long tunnels.")
+		}
+		req, err := a.newRequest("You are an AI Assistant.", "Tell me about gophers")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := requestTokens(t, a, req); n > 80 {
+			t.Errorf("%s: request has %d tokens, more than 80", tmpl.Name(), n)
+		}
+		// The markup of the template counts as well.
+		raw := a.rawRequest(req)
+		if n, _ := a.tokenCounter().CountTokens(raw.Prompt); n > 80 {
+			t.Errorf("%s: rendered prompt has %d tokens, more than 80:\n%s", tmpl.Name(), n, raw.Prompt)
+		}
+		if n := strings.Count(raw.Prompt, "Tell me about gophers"); n != 1 {
+			t.Errorf("%s: the task is rendered %d times:\n%s", tmpl.Name(), n, raw.Prompt)
+		}
+		if n := strings.Count(raw.Prompt, "Gophers dig long tunnels."); n == 0 || n == 30 {
+			t.Errorf("%s: %d of 30 results rendered, want the newest that fit", tmpl.Name(), n)
+		}
+	}
+}
+
+func TestChatTemplateNeedsRawPrompts(t *testing.T) {
+	// The options apply in any order.
+	a := NewAgent(WithChatTemplate(renderer.ChatML), WithLLM(NewOpenAI("http://localhost:1", "m")))
+	if _, err := a.Run("Tell me about gophers"); err == nil || !strings.Contains(err.Error(), "raw prompts") {
+		t.Errorf("Run error = %v, want the chat template to be rejected", err)
+	}
+	if _, err := a.ExplainPrompt("Tell me about gophers"); err == nil {
+		t.Error("ExplainPrompt accepted the chat template")
+	}
+}
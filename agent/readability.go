@@ -0,0 +1,455 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements that never hold main content.
+var strippedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Nav:      true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Iframe:   true,
+	atom.Svg:      true,
+	atom.Button:   true,
+	atom.Template: true,
+	atom.Select:   true,
+	atom.Head:     true,
+}
+
+// Roles of elements that never hold main content.
+var strippedRoles = map[string]bool{
+	"navigation":    true,
+	"banner":        true,
+	"contentinfo":   true,
+	"complementary": true,
+	"dialog":        true,
+	"alert":         true,
+}
+
+var (
+	unlikelyCandidate = regexp.MustCompile(`(?i)nav|menu|footer|sidebar|cookie|consent|banner|breadcrumb|advert|promo|share|social|comment|related|popup|modal|newsletter|subscribe`)
+	maybeCandidate    = regexp.MustCompile(`(?i)article|content|main|post|body|entry|story|text`)
+)
+
+// ExtractMarkdown extracts the main content of an HTML document and converts
+// it to Markdown. Navigation, footers, scripts and similar boilerplate are
+// dropped and relative links are resolved against base.
+func ExtractMarkdown(r io.Reader, base *url.URL) (title, markdown string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", err
+	}
+	if t := findFirst(doc, atom.Title); t != nil {
+		title = collapseSpace(textContent(t))
+	}
+	removeBoilerplate(doc)
+
+	root := mainContent(doc)
+	if root == nil {
+		return title, "", nil
+	}
+	w := &markdownWriter{base: base, lineStart: true}
+	w.render(root)
+	return title, w.String(), nil
+}
+
+// removeBoilerplate removes elements that are not part of the main content.
+func removeBoilerplate(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.CommentNode || (c.Type == html.ElementNode && isBoilerplate(c)) {
+			n.RemoveChild(c)
+		} else {
+			removeBoilerplate(c)
+		}
+		c = next
+	}
+}
+
+func isBoilerplate(n *html.Node) bool {
+	if strippedElements[n.DataAtom] || strippedRoles[attr(n, "role")] || attr(n, "aria-hidden") == "true" {
+		return true
+	}
+	if n.DataAtom == atom.Header && !hasAncestor(n, atom.Article, atom.Main) {
+		return true
+	}
+	switch n.DataAtom {
+	case atom.Div, atom.Section, atom.Ul, atom.Header:
+		hint := attr(n, "class") + " " + attr(n, "id")
+		return unlikelyCandidate.MatchString(hint) && !maybeCandidate.MatchString(hint)
+	}
+	return false
+}
+
+// mainContent picks the element holding the main content: a single article
+// or main element if present, otherwise the best scoring container.
+func mainContent(doc *html.Node) *html.Node {
+	for _, a := range []atom.Atom{atom.Article, atom.Main} {
+		if nodes := findAll(doc, a); len(nodes) == 1 && utf8.RuneCountInString(collapseSpace(textContent(nodes[0]))) > 200 {
+			return nodes[0]
+		}
+	}
+
+	body := findFirst(doc, atom.Body)
+	if body == nil {
+		return doc
+	}
+
+	// Score containers by the paragraphs they hold, readability style.
+	scores := map[*html.Node]float64{}
+	for _, p := range findAll(body, atom.P, atom.Pre, atom.Td, atom.Blockquote) {
+		text := collapseSpace(textContent(p))
+		length := utf8.RuneCountInString(text)
+		if length < 25 {
+			continue
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(minInt(length/100, 3))
+		if parent := p.Parent; parent != nil {
+			scores[parent] += score
+			if grandparent := parent.Parent; grandparent != nil {
+				scores[grandparent] += score / 2
+			}
+		}
+	}
+
+	// Visit candidates in document order, so ties go to the shallower and
+	// then to the earlier node.
+	var best *html.Node
+	bestScore := 0.0
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if score, ok := scores[n]; ok {
+			score *= 1 - linkDensity(n)
+			if score > bestScore || (score == bestScore && best != nil && depth(n) < depth(best)) {
+				best, bestScore = n, score
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(body)
+	if best == nil {
+		return body
+	}
+	return best
+}
+
+// linkDensity returns the share of n's text that is inside links.
+func linkDensity(n *html.Node) float64 {
+	textLength := len(collapseSpace(textContent(n)))
+	if textLength == 0 {
+		return 0
+	}
+	linkLength := 0
+	for _, a := range findAll(n, atom.A) {
+		linkLength += len(collapseSpace(textContent(a)))
+	}
+	return float64(linkLength) / float64(textLength)
+}
+
+// markdownWriter renders HTML nodes as Markdown.
+type markdownWriter struct {
+	b                strings.Builder
+	base             *url.URL
+	listDepth        int
+	lineStart        bool
+	pendingSpace     bool
+	trailingNewlines int
+}
+
+func (w *markdownWriter) String() string {
+	return strings.TrimSpace(w.b.String())
+}
+
+func (w *markdownWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	w.b.WriteString(s)
+	trimmed := strings.TrimRight(s, "\n")
+	if trimmed == "" {
+		w.trailingNewlines += len(s)
+	} else {
+		w.trailingNewlines = len(s) - len(trimmed)
+	}
+	w.lineStart = w.trailingNewlines > 0
+}
+
+// ensureNewlines ends the output with at least n newlines.
+func (w *markdownWriter) ensureNewlines(n int) {
+	w.pendingSpace = false
+	if w.b.Len() == 0 {
+		return
+	}
+	for w.trailingNewlines < n {
+		w.write("\n")
+	}
+}
+
+// inline writes s as inline content, separated by a space from preceding text if needed.
+func (w *markdownWriter) inline(s string, leadingSpace bool) {
+	if (w.pendingSpace || leadingSpace) && !w.lineStart {
+		w.write(" ")
+	}
+	w.pendingSpace = false
+	w.write(s)
+}
+
+// text writes s with runs of whitespace collapsed.
+func (w *markdownWriter) text(s string) {
+	collapsed := collapseSpace(s)
+	if collapsed == "" {
+		if s != "" && !w.lineStart {
+			w.pendingSpace = true
+		}
+		return
+	}
+	w.inline(collapsed, strings.TrimLeft(s, " \t\r\n") != s)
+	w.pendingSpace = strings.TrimRight(s, " \t\r\n") != s
+}
+
+func (w *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.render(c)
+	}
+}
+
+func (w *markdownWriter) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.text(n.Data)
+		return
+	case html.DocumentNode:
+		w.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		heading := collapseSpace(textContent(n))
+		if heading == "" {
+			return
+		}
+		level := int(n.Data[1] - '0')
+		w.ensureNewlines(2)
+		w.write(strings.Repeat("#", level) + " " + heading)
+		w.ensureNewlines(2)
+	case atom.Br:
+		w.write("\n")
+	case atom.Hr:
+		w.ensureNewlines(2)
+		w.write("---")
+		w.ensureNewlines(2)
+	case atom.Ul, atom.Ol:
+		if w.listDepth == 0 {
+			w.ensureNewlines(2)
+		}
+		w.listDepth++
+		index := 0
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+				w.render(c)
+				continue
+			}
+			index++
+			marker := "- "
+			if n.DataAtom == atom.Ol {
+				marker = fmt.Sprintf("%d. ", index)
+			}
+			w.ensureNewlines(1)
+			w.write(strings.Repeat("  ", w.listDepth-1) + marker)
+			w.lineStart = true
+			w.children(c)
+		}
+		w.listDepth--
+		if w.listDepth == 0 {
+			w.ensureNewlines(2)
+		}
+	case atom.Pre:
+		code := strings.Trim(textContent(n), "\n")
+		if strings.TrimSpace(code) == "" {
+			return
+		}
+		w.ensureNewlines(2)
+		w.write("```\n" + code + "\n```")
+		w.ensureNewlines(2)
+	case atom.Code:
+		if code := collapseSpace(textContent(n)); code != "" {
+			w.inline("`"+code+"`", false)
+		}
+	case atom.A:
+		text := collapseSpace(textContent(n))
+		href := w.resolve(attr(n, "href"))
+		switch {
+		case text == "":
+		case href == "":
+			w.text(textContent(n))
+		default:
+			w.inline(fmt.Sprintf("[%s](%s)", text, href), false)
+		}
+	case atom.Strong, atom.B:
+		if text := collapseSpace(textContent(n)); text != "" {
+			w.inline("**"+text+"**", false)
+		}
+	case atom.Em, atom.I:
+		if text := collapseSpace(textContent(n)); text != "" {
+			w.inline("*"+text+"*", false)
+		}
+	case atom.Img:
+		if alt, src := collapseSpace(attr(n, "alt")), w.resolve(attr(n, "src")); alt != "" && src != "" {
+			w.inline(fmt.Sprintf("![%s](%s)", alt, src), false)
+		}
+	case atom.Blockquote:
+		inner := &markdownWriter{base: w.base, lineStart: true}
+		inner.children(n)
+		if quote := inner.String(); quote != "" {
+			w.ensureNewlines(2)
+			w.write("> " + strings.ReplaceAll(quote, "\n", "\n> "))
+			w.ensureNewlines(2)
+		}
+	case atom.Table:
+		w.table(n)
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Main, atom.Header,
+		atom.Figure, atom.Figcaption, atom.Dl, atom.Dt, atom.Dd, atom.Details, atom.Summary:
+		w.ensureNewlines(2)
+		w.children(n)
+		w.ensureNewlines(2)
+	default:
+		w.children(n)
+	}
+}
+
+// table renders n as a Markdown table, using its first row as the header.
+func (w *markdownWriter) table(n *html.Node) {
+	var rows [][]string
+	for _, tr := range findAll(n, atom.Tr) {
+		var cells []string
+		for c := tr.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.DataAtom == atom.Td || c.DataAtom == atom.Th) {
+				cells = append(cells, strings.ReplaceAll(collapseSpace(textContent(c)), "|", "\\|"))
+			}
+		}
+		if len(cells) > 0 {
+			rows = append(rows, cells)
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	w.ensureNewlines(2)
+	for i, row := range rows {
+		w.write("| " + strings.Join(row, " | ") + " |\n")
+		if i == 0 {
+			w.write(strings.Repeat("| --- ", len(row)) + "|\n")
+		}
+	}
+	w.ensureNewlines(2)
+}
+
+// resolve makes href absolute, dropping fragments and script links.
+func (w *markdownWriter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if w.base != nil {
+		u = w.base.ResolveReference(u)
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func findFirst(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findFirst(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func findAll(n *html.Node, atoms ...atom.Atom) []*html.Node {
+	var result []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				for _, a := range atoms {
+					if c.DataAtom == a {
+						result = append(result, c)
+						break
+					}
+				}
+			}
+			walk(c)
+		}
+	}
+	walk(n)
+	return result
+}
+
+func hasAncestor(n *html.Node, atoms ...atom.Atom) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		for _, a := range atoms {
+			if p.DataAtom == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func depth(n *html.Node) int {
+	d := 0
+	for p := n.Parent; p != nil; p = p.Parent {
+		d++
+	}
+	return d
+}
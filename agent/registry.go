@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrToolExists is returned when registering a tool whose name is already taken.
+var ErrToolExists = errors.New("tool already registered")
+
+// ToolRegistry is an ordered set of tools with unique, case-insensitive names.
+// It is safe for concurrent use.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools []Tool
+}
+
+// NewToolRegistry creates a registry holding tools.
+func NewToolRegistry(tools ...Tool) (*ToolRegistry, error) {
+	r := &ToolRegistry{}
+	for _, tool := range tools {
+		if err := r.Register(tool); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds tool to the registry. It fails with ErrToolExists if a tool
+// with the same name, ignoring case, is already registered.
+func (r *ToolRegistry) Register(tool Tool) error {
+	if tool.Name() == "" {
+		return fmt.Errorf("tool name must not be empty")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(tool.Name()); i >= 0 {
+		return fmt.Errorf("%w: %s collides with %s", ErrToolExists, tool.Name(), r.tools[i].Name())
+	}
+	r.tools = append(r.tools, tool)
+	return nil
+}
+
+// Set registers tool, replacing a tool with the same name in place.
+func (r *ToolRegistry) Set(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(tool.Name()); i >= 0 {
+		r.tools[i] = tool
+		return
+	}
+	r.tools = append(r.tools, tool)
+}
+
+// Replace swaps in tool for the registered tool of the same name and reports
+// whether there was one. Unlike Set it never adds a tool.
+func (r *ToolRegistry) Replace(tool Tool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.index(tool.Name())
+	if i < 0 {
+		return false
+	}
+	r.tools[i] = tool
+	return true
+}
+
+// Unregister removes the tool with the given name and reports whether it was registered.
+func (r *ToolRegistry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := r.index(name)
+	if i < 0 {
+		return false
+	}
+	r.tools = append(r.tools[:i], r.tools[i+1:]...)
+	return true
+}
+
+// Get returns the tool with the given name, ignoring case.
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if i := r.index(name); i >= 0 {
+		return r.tools[i], true
+	}
+	return nil, false
+}
+
+// index returns the position of the tool with the given name, or -1.
+// The caller must hold r.mu.
+func (r *ToolRegistry) index(name string) int {
+	for i, tool := range r.tools {
+		if strings.EqualFold(tool.Name(), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// List returns the registered tools in registration order.
+func (r *ToolRegistry) List() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tools := make([]Tool, len(r.tools))
+	copy(tools, r.tools)
+	return tools
+}
+
+// Names returns the names of the registered tools in registration order.
+func (r *ToolRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.tools))
+	for i, tool := range r.tools {
+		names[i] = tool.Name()
+	}
+	return names
+}
+
+// DefaultTools returns the tools registered by NewAgent.
+func DefaultTools() []Tool {
+	return []Tool{
+		SearchTool(),
+		BrowseTool(),
+		FunctionInfo{FunctionName: "CurrentTime", FunctionDescription: "This function is useful when you need the current time", FunctionInput: "N/A", FunctionRef: CurrentTime}.Tool(),
+		FunctionInfo{FunctionName: "Finish", FunctionDescription: "This is useful when the agent decides to finish this task and generate output.", FunctionInput: "The output that you want to print as the result of your task as detailed as possible.", FunctionRef: Finish}.Tool(),
+	}
+}
+
+// WithTools registers additional tools. A name collision makes Run fail.
+func WithTools(tools ...Tool) AgentOption {
+	return func(a *Agent) {
+		for _, tool := range tools {
+			if err := a.RegisterTool(tool); err != nil && a.configErr == nil {
+				a.configErr = err
+			}
+		}
+	}
+}
+
+// WithFunctions registers FunctionInfo values as tools. A name collision makes Run fail.
+func WithFunctions(functions ...FunctionInfo) AgentOption {
+	tools := make([]Tool, len(functions))
+	for i, fn := range functions {
+		tools[i] = fn.Tool()
+	}
+	return WithTools(tools...)
+}
+
+// WithoutTools removes tools by name, e.g. WithoutTools("Browse").
+func WithoutTools(names ...string) AgentOption {
+	return func(a *Agent) {
+		for _, name := range names {
+			a.Tools.Unregister(name)
+		}
+	}
+}
+
+// WithToolRegistry replaces the agent's tools with registry.
+// A nil registry makes Run fail.
+func WithToolRegistry(registry *ToolRegistry) AgentOption {
+	return func(a *Agent) {
+		if registry == nil {
+			if a.configErr == nil {
+				a.configErr = fmt.Errorf("tool registry must not be nil")
+			}
+			return
+		}
+		a.Tools = registry
+	}
+}
+
+// RegisterTool adds a tool to the agent.
+func (a *Agent) RegisterTool(tool Tool) error {
+	return a.Tools.Register(tool)
+}
+
+// registerFunctions adds the deprecated Agent.Functions to the agent's
+// tools, replacing tools of the same name.
+func (a *Agent) registerFunctions() {
+	for _, fn := range a.Functions {
+		a.Tools.Set(fn.Tool())
+	}
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func echoTool(name string) Tool {
+	return FunctionInfo{FunctionName: name, FunctionDescription: name + " tool", FunctionInput: "text", FunctionRef: func(s string) string { return s }}.Tool()
+}
+
+func TestToolRegistry(t *testing.T) {
+	r, err := NewToolRegistry(echoTool("Search"), echoTool("Browse"))
+	if err != nil {
+		t.Fatalf("NewToolRegistry: %v", err)
+	}
+	if err := r.Register(echoTool("search")); !errors.Is(err, ErrToolExists) {
+		t.Errorf("Register(search) = %v, want ErrToolExists", err)
+	}
+	if err := r.Register(echoTool("")); err == nil {
+		t.Error("Register accepted an empty name")
+	}
+
+	replacement := NewTool("SEARCH", "replaced", "q", nil)
+	r.Set(replacement)
+	r.Set(echoTool("Finish"))
+	if got, want := r.Names(), []string{"SEARCH", "Browse", "Finish"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %q, want %q", got, want)
+	}
+	if tool, ok := r.Get("search"); !ok || tool != replacement {
+		t.Errorf("Get(search) = %v, %v, want the replacement", tool, ok)
+	}
+
+	if r.Replace(echoTool("Missing")) {
+		t.Error("Replace added an unregistered tool")
+	}
+	if !r.Unregister("browse") || r.Unregister("browse") {
+		t.Error("Unregister(browse) should succeed exactly once")
+	}
+	if got := len(r.List()); got != 2 {
+		t.Errorf("len(List()) = %d, want 2", got)
+	}
+}
+
+func TestToolRegistryIsSafeForConcurrentUse(t *testing.T) {
+	r, _ := NewToolRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("Tool%d", i)
+			r.Register(echoTool(name))
+			r.Get(name)
+			r.Names()
+			r.Set(echoTool(name))
+			r.List()
+		}(i)
+	}
+	wg.Wait()
+	if got := len(r.Names()); got != 8 {
+		t.Errorf("registered %d tools, want 8", got)
+	}
+}
+
+func TestWithToolsRejectsCollisions(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithTools(echoTool("finish")))
+	if _, err := a.Run("hello"); !errors.Is(err, ErrToolExists) {
+		t.Fatalf("Run error = %v, want ErrToolExists", err)
+	}
+}
+
+func TestWithToolRegistryRejectsNil(t *testing.T) {
+	a := newTestAgent(&fakeLLM{}, WithToolRegistry(nil))
+	if a.Tools == nil {
+		t.Fatal("nil registry replaced the agent's tools")
+	}
+	if _, err := a.Run("hello"); err == nil {
+		t.Fatal("Run succeeded with a nil tool registry")
+	}
+}
+
+func TestDeprecatedFunctionsAreRegistered(t *testing.T) {
+	var got string
+	llm := &fakeLLM{replies: []string{"Function: Legacy\nInput: x", "Function: Finish\nInput: done"}}
+	a := newTestAgent(llm)
+	a.Functions = []FunctionInfo{{
+		FunctionName:        "Legacy",
+		FunctionDescription: "An old style function",
+		FunctionInput:       "text",
+		FunctionRef:         func(input string) string { got = input; return "ok" },
+	}}
+
+	if _, err := a.Run("hello"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if got != "x" {
+		t.Errorf("Legacy input = %q, want x", got)
+	}
+	// A second run must not fail on the already registered function.
+	llm.replies = []string{"Function: Finish\nInput: done"}
+	if _, err := a.Run("again"); err != nil {
+		t.Fatalf("second Run: %v", err)
+	}
+}
+
+func TestFunctionsAsString(t *testing.T) {
+	functions := []FunctionInfo{{FunctionName: "Search", FunctionDescription: "Searches", FunctionInput: "query"}}
+	got := FunctionsAsString(functions)
+	if got != ToolsAsString([]Tool{functions[0].Tool()}) || !strings.Contains(got, "Function: Search") {
+		t.Errorf("FunctionsAsString = %q", got)
+	}
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// RepairOptions configures how the agent recovers from model output that
+// cannot be parsed into a function call.
+type RepairOptions struct {
+	MaxAttempts int  // Number of repair rounds before giving up, 0 fails immediately; every round makes one model call per enabled strategy
+	Reask       bool // Ask the model again, telling it why its output was rejected
+	Restructure bool // Ask the model to restructure its previous output into the expected format
+}
+
+// DefaultRepairOptions returns the repair strategy used by NewAgent.
+func DefaultRepairOptions() RepairOptions {
+	return RepairOptions{
+		MaxAttempts: 2,
+		Reask:       true,
+		Restructure: true,
+	}
+}
+
+// WithRepair sets the strategy for recovering from unparsable model output.
+func WithRepair(opts RepairOptions) AgentOption {
+	return func(a *Agent) {
+		a.Repair = opts
+	}
+}
+
+// ParseError is returned by Run when the model output could not be parsed
+// even after every repair attempt.
+type ParseError struct {
+	Raw      string // The original model output
+	Attempts int    // Number of repair rounds tried
+	Err      error  // The last parse error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("could not parse model output after %d repair attempts: %v. Output <<<<<<%s>>>>>", e.Attempts, e.Err, e.Raw)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// repairOutput tries to turn raw, which failed to parse with parseErr, into a
+// function call using the configured repair strategy. Every strategy works on
+// the latest output of the model. Repairs stop early when the context is done
+// or a token budget runs out.
+func (a *Agent) repairOutput(ctx context.Context, system, prompt, raw string, parseErr error) (Action, error) {
+	attempts := 0
+	latest := raw
+	for attempts < a.Repair.MaxAttempts {
+		if err := ctx.Err(); err != nil {
+			return Action{}, err
+		}
+		attempts++
+		log.Printf("Repairing unparsable output, attempt %d: %v", attempts, parseErr)
+
+		if a.Repair.Reask {
+			req, err := a.newRequest(system, prompt)
+			if err != nil {
+				return Action{}, err
+			}
+			feedback := fmt.Sprintf("Your previous response could not be used: %v.\nPrevious response:\n%s\n\nRespond again with exactly one function using the OUTPUT_FORMAT.", parseErr, latest)
+			req.Prompt = strings.TrimSpace(req.Prompt + "\n\n" + feedback)
+			resp, err := a.generate(ctx, StageAction, req)
+			if err != nil {
+				return Action{}, err
+			}
+			latest = resp.Response
+			action, err := a.parseAction(latest)
+			if err == nil {
+				return action, nil
+			}
+			parseErr = err
+		}
+
+		if a.Repair.Restructure {
+			restructured, err := a.restructureOutput(ctx, latest)
+			if err != nil {
+				return Action{}, err
+			}
+			action, err := a.parseAction(restructured)
+			if err == nil {
+				return action, nil
+			}
+			parseErr = err
+		}
+	}
+	return Action{}, &ParseError{Raw: raw, Attempts: attempts, Err: parseErr}
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRestructureUsesTheLatestOutput(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"first try", "second try", "Function: Finish\nInput: ok"}}
+	a := newTestAgent(llm, WithRepair(RepairOptions{MaxAttempts: 1, Reask: true, Restructure: true}))
+
+	if got, err := a.Run("hello"); err != nil || got != "ok" {
+		t.Fatalf("Run = %q, %v, want ok", got, err)
+	}
+	actions := llm.stageRequests(StageAction)
+	if len(actions) != 3 {
+		t.Fatalf("action requests = %d, want 3", len(actions))
+	}
+	if !strings.Contains(actions[1].Prompt, "first try") {
+		t.Errorf("reask does not quote the rejected output:\n%s", actions[1].Prompt)
+	}
+	if actions[2].Prompt != "second try" {
+		t.Errorf("restructured %q, want the reasked output", actions[2].Prompt)
+	}
+}
+
+func TestRepairGivesUpAfterMaxAttempts(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"a", "b", "c", "d", "e", "Function: Finish\nInput: too late"}}
+	a := newTestAgent(llm, WithRepair(RepairOptions{MaxAttempts: 2, Reask: true, Restructure: true}))
+
+	_, err := a.Run("hello")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Run error = %v, want a *ParseError", err)
+	}
+	if parseErr.Raw != "a" || parseErr.Attempts != 2 {
+		t.Errorf("ParseError = %+v, want the first output after 2 attempts", parseErr)
+	}
+	if n := len(llm.stageRequests(StageAction)); n != 5 {
+		t.Errorf("action requests = %d, want 1 plus 2 rounds of 2", n)
+	}
+}
+
+func TestRepairDisabled(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"gibberish"}}
+	a := newTestAgent(llm, WithRepair(RepairOptions{}))
+
+	var parseErr *ParseError
+	if _, err := a.Run("hello"); !errors.As(err, &parseErr) || parseErr.Attempts != 0 {
+		t.Fatalf("Run error = %v, want a *ParseError without attempts", err)
+	}
+}
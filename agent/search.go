@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	serpapi "github.com/serpapi/google-search-results-golang"
+)
+
+// SearchResult is a single web search hit.
+type SearchResult struct {
+	Title   string `json:"title"`
+	Link    string `json:"link"`
+	Snippet string `json:"snippet"`
+	Rank    int    `json:"rank"` // 1-based position in the result list
+}
+
+// SearchProvider runs web searches.
+type SearchProvider interface {
+	Search(ctx context.Context, query string) ([]SearchResult, error)
+}
+
+// WithSearchProvider makes the Search tool use provider. It does not add
+// Search back if it was removed; register NewSearchTool(provider) for that.
+func WithSearchProvider(provider SearchProvider) AgentOption {
+	return func(a *Agent) {
+		a.Tools.Replace(NewSearchTool(provider))
+	}
+}
+
+// NewSearchTool returns a Search tool backed by provider.
+func NewSearchTool(provider SearchProvider) Tool {
+	return NewTool("Search", "This search is useful to get reliable quick data.", "Text to be searched",
+		func(ctx context.Context, query string) (string, error) {
+			results, err := provider.Search(ctx, query)
+			if err != nil {
+				return "", err
+			}
+			if len(results) == 0 {
+				return fmt.Sprintf("No results found for %q.\n", query), nil
+			}
+			return FormatSearchResults(results), nil
+		})
+}
+
+// FormatSearchResults renders results for the conversation.
+func FormatSearchResults(results []SearchResult) string {
+	var allResults strings.Builder
+	for _, result := range results {
+		allResults.WriteString(fmt.Sprintf("Title: %s\nLink: %s\nSnippet: %s\n\n", result.Title, result.Link, result.Snippet))
+	}
+	return allResults.String()
+}
+
+// SerpAPIProvider searches Google through SerpAPI.
+type SerpAPIProvider struct {
+	APIKey string // Defaults to $SERP_API_KEY
+}
+
+// Search returns the organic results for query.
+func (p *SerpAPIProvider) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	serpAPIKey := p.APIKey
+	if serpAPIKey == "" {
+		serpAPIKey = os.Getenv("SERP_API_KEY")
+	}
+	if serpAPIKey == "" {
+		return nil, fmt.Errorf("SERP_API_KEY is not set in the environment")
+	}
+
+	parameter := map[string]string{
+		"q": query,
+	}
+	search := serpapi.NewGoogleSearch(parameter, serpAPIKey)
+
+	// The SerpAPI client does not accept a context, so wait for it in the background.
+	type searchResponse struct {
+		data serpapi.SearchResult
+		err  error
+	}
+	done := make(chan searchResponse, 1)
+	go func() {
+		data, err := search.GetJSON()
+		done <- searchResponse{data, err}
+	}()
+
+	var data serpapi.SearchResult
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		data = r.data
+	}
+
+	organic, _ := data["organic_results"].([]interface{})
+	results := make([]SearchResult, 0, len(organic))
+	for _, r := range organic {
+		result, ok := r.(map[string]interface{})
+		if !ok {
+			// Handle the case where the type assertion fails
+			continue
+		}
+		results = append(results, SearchResult{
+			Title:   safeString(result, "title"),
+			Link:    safeString(result, "link"),
+			Snippet: safeString(result, "snippet"),
+			Rank:    len(results) + 1,
+		})
+	}
+	return results, nil
+}
+
+// SearXNGProvider searches a self-hosted SearXNG instance through its JSON API.
+// The instance must have the json format enabled in its settings.
+type SearXNGProvider struct {
+	BaseURL    string       // Base URL of the instance, defaults to $SEARXNG_URL
+	Categories string       // Optional comma separated categories, e.g. "general"
+	Language   string       // Optional language code, e.g. "en"
+	Client     *http.Client // HTTP client, defaults to http.DefaultClient
+}
+
+// Search returns the results for query.
+func (p *SearXNGProvider) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = os.Getenv("SEARXNG_URL")
+	}
+	if baseURL == "" {
+		return nil, fmt.Errorf("SEARXNG_URL is not set in the environment")
+	}
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("format", "json")
+	if p.Categories != "" {
+		params.Set("categories", p.Categories)
+	}
+	if p.Language != "" {
+		params.Set("language", p.Language)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(baseURL, "/")+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("SearXNG Error code: %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, len(response.Results))
+	for i, r := range response.Results {
+		results[i] = SearchResult{
+			Title:   r.Title,
+			Link:    r.URL,
+			Snippet: r.Content,
+			Rank:    i + 1,
+		}
+	}
+	return results, nil
+}
+
+// FixtureProvider serves canned results from a JSON file, for tests and offline runs.
+// The file maps queries to result lists; the key "*" matches any other query:
+//
+//	{"golang": [{"title": "The Go Programming Language", "link": "https://go.dev", "snippet": "..."}]}
+type FixtureProvider struct {
+	Path string
+
+	once     sync.Once
+	fixtures map[string][]SearchResult
+	err      error
+}
+
+// NewFixtureProvider creates a provider reading fixtures from path.
+func NewFixtureProvider(path string) *FixtureProvider {
+	return &FixtureProvider{Path: path}
+}
+
+func (p *FixtureProvider) load() {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		p.err = err
+		return
+	}
+	var fixtures map[string][]SearchResult
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		p.err = fmt.Errorf("parsing search fixtures %s: %v", p.Path, err)
+		return
+	}
+	p.fixtures = make(map[string][]SearchResult, len(fixtures))
+	for query, results := range fixtures {
+		for i := range results {
+			if results[i].Rank == 0 {
+				results[i].Rank = i + 1
+			}
+		}
+		p.fixtures[normalizeQuery(query)] = results
+	}
+}
+
+// Search returns the fixture for query, ignoring case and surrounding whitespace.
+func (p *FixtureProvider) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p.once.Do(p.load)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if results, ok := p.fixtures[normalizeQuery(query)]; ok {
+		return results, nil
+	}
+	if results, ok := p.fixtures["*"]; ok {
+		return results, nil
+	}
+	return nil, fmt.Errorf("no search fixture for query %q", query)
+}
+
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/prompt"
+)
+
+func writeFixture(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "search.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFixtureProvider(t *testing.T) {
+	path := writeFixture(t, `{
+		"Golang": [{"title": "Go", "link": "https://go.dev", "snippet": "Build simple software"}, {"title": "Tour", "link": "https://go.dev/tour"}],
+		"*": [{"title": "Anything", "link": "https://example.com"}]
+	}`)
+	p := NewFixtureProvider(path)
+	ctx := context.Background()
+
+	results, err := p.Search(ctx, "  golang ")
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	want := []SearchResult{
+		{Title: "Go", Link: "https://go.dev", Snippet: "Build simple software", Rank: 1},
+		{Title: "Tour", Link: "https://go.dev/tour", Rank: 2},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+	if results, err := p.Search(ctx, "rust"); err != nil || len(results) != 1 || results[0].Title != "Anything" {
+		t.Errorf("wildcard = %+v, %v", results, err)
+	}
+}
+
+func TestFixtureProviderErrors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewFixtureProvider(writeFixture(t, `{"go": []}`)).Search(ctx, "rust"); err == nil {
+		t.Error("missing fixture without a wildcard returned no error")
+	}
+	if _, err := NewFixtureProvider(writeFixture(t, `[]`)).Search(ctx, "go"); err == nil || !strings.Contains(err.Error(), "parsing search fixtures") {
+		t.Errorf("malformed fixture error = %v", err)
+	}
+	if _, err := NewFixtureProvider(filepath.Join(t.TempDir(), "missing.json")).Search(ctx, "go"); !os.IsNotExist(err) {
+		t.Errorf("missing file error = %v", err)
+	}
+}
+
+func TestSearXNGProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/search" || q.Get("q") != "go generics" || q.Get("format") != "json" || q.Get("language") != "en" || q.Get("categories") != "it" {
+			t.Errorf("request = %s", r.URL)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"results": []map[string]string{
+				{"title": "Generics", "url": "https://go.dev/doc/tutorial/generics", "content": "Tutorial"},
+				{"title": "Spec", "url": "https://go.dev/ref/spec"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	p := &SearXNGProvider{BaseURL: server.URL + "/", Language: "en", Categories: "it"}
+	results, err := p.Search(context.Background(), "go generics")
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	want := []SearchResult{
+		{Title: "Generics", Link: "https://go.dev/doc/tutorial/generics", Snippet: "Tutorial", Rank: 1},
+		{Title: "Spec", Link: "https://go.dev/ref/spec", Rank: 2},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+}
+
+func TestSearXNGProviderErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "format not allowed", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	if _, err := (&SearXNGProvider{BaseURL: server.URL}).Search(context.Background(), "go"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("error = %v, want status 403", err)
+	}
+	t.Setenv("SEARXNG_URL", "")
+	if _, err := (&SearXNGProvider{}).Search(context.Background(), "go"); err == nil {
+		t.Error("search without a base URL returned no error")
+	}
+}
+
+// staticProvider returns the same results for every query.
+type staticProvider []SearchResult
+
+func (p staticProvider) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	return p, nil
+}
+
+func TestSearchTool(t *testing.T) {
+	tool := NewSearchTool(staticProvider{{Title: "Go", Link: "https://go.dev", Snippet: "Go"}})
+	result, err := tool.Call(context.Background(), json.RawMessage(`{"input":"go"}`))
+	if err != nil || result.Content != "Title: Go\nLink: https://go.dev\nSnippet: Go\n\n" {
+		t.Errorf("Call = %q, %v", result.Content, err)
+	}
+
+	result, err = NewSearchTool(staticProvider{}).Call(context.Background(), json.RawMessage(`"go"`))
+	if err != nil || result.Content != fmt.Sprintf("No results found for %q.\n", "go") {
+		t.Errorf("no results = %q, %v", result.Content, err)
+	}
+}
+
+func TestWithSearchProviderReplacesSearch(t *testing.T) {
+	provider := staticProvider{{Title: "Go"}}
+	a := NewAgent(WithLLM(&fakeLLM{}), WithTokenCounter(prompt.HeuristicCounter{}), WithSearchProvider(provider))
+	tool, ok := a.Tools.Get("Search")
+	if !ok {
+		t.Fatal("Search is not registered")
+	}
+	if result, _ := tool.Call(context.Background(), json.RawMessage(`"go"`)); !strings.Contains(result.Content, "Title: Go") {
+		t.Errorf("Search does not use the provider: %q", result.Content)
+	}
+	if names := a.Tools.Names(); names[0] != "Search" {
+		t.Errorf("Search moved to %q", names)
+	}
+}
+
+func TestWithSearchProviderKeepsSearchRemoved(t *testing.T) {
+	a := NewAgent(WithLLM(&fakeLLM{}), WithoutTools("Search"), WithSearchProvider(staticProvider{}))
+	if _, ok := a.Tools.Get("Search"); ok {
+		t.Error("WithSearchProvider added Search back")
+	}
+}
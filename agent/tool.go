@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Result is the outcome of a successful tool call.
+type Result struct {
+	Content string // Text added to the conversation
+	Source  string // URL the content was fetched from; the agent digests such results
+}
+
+// Tool is a function the model can call.
+type Tool interface {
+	// Name is the identifier the model uses to call the tool.
+	Name() string
+	// Description tells the model when the tool is useful.
+	Description() string
+	// Schema is the JSON Schema of the arguments object.
+	Schema() json.RawMessage
+	// Call runs the tool. Failures are reported as errors, not as content.
+	Call(ctx context.Context, args json.RawMessage) (Result, error)
+}
+
+// InputSchema returns the JSON Schema of an arguments object with a single
+// "input" string, the shape used by tools adapted from FunctionInfo.
+func InputSchema(description string) json.RawMessage {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"input": map[string]interface{}{
+				"type":        "string",
+				"description": description,
+			},
+		},
+		"required": []string{"input"},
+	}
+	data, _ := json.Marshal(schema)
+	return data
+}
+
+// StringArgument returns the string argument name from args.
+// A bare JSON string is accepted as shorthand for a single argument.
+func StringArgument(args json.RawMessage, name string) (string, error) {
+	var value string
+	if err := json.Unmarshal(args, &value); err == nil {
+		return value, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(args, &object); err != nil {
+		return "", fmt.Errorf("arguments must be a JSON object")
+	}
+	raw, ok := object[name]
+	if !ok {
+		return "", fmt.Errorf("missing required argument %q", name)
+	}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("argument %q must be a string", name)
+	}
+	return value, nil
+}
+
+// Tool adapts f to the Tool interface. Its arguments are a single "input" string.
+func (f FunctionInfo) Tool() Tool {
+	return functionTool{f}
+}
+
+type functionTool struct {
+	fn FunctionInfo
+}
+
+func (t functionTool) Name() string            { return t.fn.FunctionName }
+func (t functionTool) Description() string     { return t.fn.FunctionDescription }
+func (t functionTool) Schema() json.RawMessage { return InputSchema(t.fn.FunctionInput) }
+
+func (t functionTool) Call(ctx context.Context, args json.RawMessage) (Result, error) {
+	input, err := argumentsAsInput(args)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Content: t.fn.call(ctx, input)}, nil
+}
+
+// NewTool creates a tool from a function taking a single "input" string.
+func NewTool(name, description, inputDescription string, fn func(ctx context.Context, input string) (string, error)) Tool {
+	return &inputTool{name: name, description: description, inputDescription: inputDescription, fn: fn}
+}
+
+type inputTool struct {
+	name             string
+	description      string
+	inputDescription string
+	fn               func(ctx context.Context, input string) (string, error)
+}
+
+func (t *inputTool) Name() string            { return t.name }
+func (t *inputTool) Description() string     { return t.description }
+func (t *inputTool) Schema() json.RawMessage { return InputSchema(t.inputDescription) }
+
+func (t *inputTool) Call(ctx context.Context, args json.RawMessage) (Result, error) {
+	input, err := StringArgument(args, "input")
+	if err != nil {
+		return Result{}, err
+	}
+	content, err := t.fn(ctx, input)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Content: content}, nil
+}
+
+// SearchTool returns a tool that searches the web with SerpAPI.
+func SearchTool() Tool {
+	return NewSearchTool(&SerpAPIProvider{})
+}
+
+// BrowseTool returns a tool that returns the content of a web page using
+// DefaultFetcher with the browser pool shared by the package.
+func BrowseTool() Tool {
+	return NewBrowseTool(DefaultFetcher(nil))
+}
+
+// toolSchema is the subset of JSON Schema used to describe and validate arguments.
+type toolSchema struct {
+	Properties map[string]struct {
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	} `json:"properties"`
+	Required []string `json:"required"`
+}
+
+func parseToolSchema(tool Tool) toolSchema {
+	var schema toolSchema
+	_ = json.Unmarshal(tool.Schema(), &schema)
+	return schema
+}
+
+// inputOnly reports whether the schema takes a single "input" argument.
+func (s toolSchema) inputOnly() bool {
+	_, ok := s.Properties["input"]
+	return ok && len(s.Properties) == 1
+}
+
+// validateArguments checks args against the tool's schema.
+// A bare string is accepted for tools taking a single "input" argument.
+func validateArguments(tool Tool, args json.RawMessage) error {
+	schema := parseToolSchema(tool)
+	if len(args) == 0 || string(args) == "null" {
+		if len(schema.Required) > 0 {
+			return fmt.Errorf("function %s is missing required arguments %s", tool.Name(), strings.Join(schema.Required, ", "))
+		}
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(args, &value); err == nil {
+		if schema.inputOnly() || len(schema.Properties) == 0 {
+			return nil
+		}
+		return fmt.Errorf("function %s takes an arguments object", tool.Name())
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(args, &object); err != nil {
+		return fmt.Errorf("\"arguments\" must be a string or an object")
+	}
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return fmt.Errorf("function %s is missing required argument %q", tool.Name(), name)
+		}
+	}
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if ok && !hasJSONType(object[name], property.Type) {
+			return fmt.Errorf("function %s argument %q must be of type %s", tool.Name(), name, property.Type)
+		}
+	}
+	return nil
+}
+
+// hasJSONType reports whether value matches the JSON Schema type typ.
+// An empty or unknown type matches every value.
+func hasJSONType(value json.RawMessage, typ string) bool {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return false
+	}
+	switch typ {
+	case "string":
+		return value[0] == '"'
+	case "object":
+		return value[0] == '{'
+	case "array":
+		return value[0] == '['
+	case "boolean":
+		return string(value) == "true" || string(value) == "false"
+	case "null":
+		return string(value) == "null"
+	case "number", "integer":
+		var number float64
+		if err := json.Unmarshal(value, &number); err != nil {
+			return false
+		}
+		return typ == "number" || number == math.Trunc(number)
+	}
+	return true
+}
+
+// argumentsFromInput converts the plain text input of the text action format
+// into tool arguments. Input that is a JSON object is passed through as is.
+func argumentsFromInput(input string) json.RawMessage {
+	trimmed := strings.TrimSpace(input)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	data, _ := json.Marshal(input)
+	return data
+}
+
+// findFunctionByName returns the registered tool with the given name, ignoring case.
+func findFunctionByName(registry *ToolRegistry, name string) (Tool, bool) {
+	return registry.Get(name)
+}
+
+// ToolsAsString renders tools for the prompt.
+func ToolsAsString(tools []Tool) string {
+	var result strings.Builder
+	result.WriteString("------\n")
+	result.WriteString("Functions:\n")
+	for _, tool := range tools {
+		result.WriteString(fmt.Sprintf("- %s:\n", tool.Description()))
+		result.WriteString(fmt.Sprintf("Function: %s\n", tool.Name()))
+		schema := parseToolSchema(tool)
+		if schema.inputOnly() {
+			result.WriteString(fmt.Sprintf("Input: %s\n", schema.Properties["input"].Description))
+		} else {
+			result.WriteString(fmt.Sprintf("Input: JSON object matching the schema %s\n", tool.Schema()))
+		}
+	}
+	result.WriteString("------\n")
+	return result.String()
+}
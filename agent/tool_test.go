@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+)
+
+// schemaTool is a tool with an explicit schema that returns its arguments.
+type schemaTool struct {
+	name   string
+	schema string
+}
+
+func (t schemaTool) Name() string            { return t.name }
+func (t schemaTool) Description() string     { return "test tool" }
+func (t schemaTool) Schema() json.RawMessage { return json.RawMessage(t.schema) }
+
+func (t schemaTool) Call(ctx context.Context, args json.RawMessage) (Result, error) {
+	return Result{Content: string(args)}, nil
+}
+
+func TestValidateArguments(t *testing.T) {
+	fetch := schemaTool{name: "Fetch", schema: `{
+		"type": "object",
+		"properties": {
+			"url": {"type": "string"},
+			"depth": {"type": "integer"},
+			"ratio": {"type": "number"},
+			"render": {"type": "boolean"},
+			"headers": {"type": "object"},
+			"tags": {"type": "array"},
+			"note": {}
+		},
+		"required": ["url"]
+	}`}
+	input := NewTool("Search", "Searches", "query", nil)
+	free := schemaTool{name: "Free", schema: `{}`}
+
+	tests := []struct {
+		name    string
+		tool    Tool
+		args    string
+		wantErr string
+	}{
+		{name: "all types", tool: fetch, args: `{"url":"x","depth":2,"ratio":0.5,"render":false,"headers":{},"tags":[],"note":1}`},
+		{name: "extra arguments are allowed", tool: fetch, args: `{"url":"x","other":1}`},
+		{name: "missing required", tool: fetch, args: `{"depth":2}`, wantErr: `missing required argument "url"`},
+		{name: "missing arguments", tool: fetch, args: ``, wantErr: "missing required arguments url"},
+		{name: "wrong string type", tool: fetch, args: `{"url":1}`, wantErr: `"url" must be of type string`},
+		{name: "fractional integer", tool: fetch, args: `{"url":"x","depth":1.5}`, wantErr: `"depth" must be of type integer`},
+		{name: "string boolean", tool: fetch, args: `{"url":"x","render":"yes"}`, wantErr: `"render" must be of type boolean`},
+		{name: "bare string for an object schema", tool: fetch, args: `"x"`, wantErr: "takes an arguments object"},
+		{name: "array arguments", tool: fetch, args: `[1]`, wantErr: "must be a string or an object"},
+		{name: "bare string for an input tool", tool: input, args: `"gophers"`},
+		{name: "bare string without a schema", tool: free, args: `"anything"`},
+		{name: "no arguments without a schema", tool: free, args: `null`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateArguments(tt.tool, json.RawMessage(tt.args))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateArguments: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStringArgument(t *testing.T) {
+	if got, err := StringArgument(json.RawMessage(`{"url":"x"}`), "url"); err != nil || got != "x" {
+		t.Errorf("object = %q, %v", got, err)
+	}
+	if got, err := StringArgument(json.RawMessage(`"x"`), "url"); err != nil || got != "x" {
+		t.Errorf("bare string = %q, %v", got, err)
+	}
+	if _, err := StringArgument(json.RawMessage(`{}`), "url"); err == nil {
+		t.Error("missing argument accepted")
+	}
+	if _, err := StringArgument(json.RawMessage(`{"url":3}`), "url"); err == nil {
+		t.Error("number accepted as a string")
+	}
+}
+
+func TestFunctionInfoAdapter(t *testing.T) {
+	info := FunctionInfo{
+		FunctionName:        "Upper",
+		FunctionDescription: "Upper cases text",
+		FunctionInput:       "text",
+		FunctionRef:         strings.ToUpper,
+	}
+	tool := info.Tool()
+	if tool.Name() != "Upper" || tool.Description() != "Upper cases text" {
+		t.Errorf("tool = %s: %s", tool.Name(), tool.Description())
+	}
+	if err := validateArguments(tool, json.RawMessage(`{"input":"x"}`)); err != nil {
+		t.Errorf("input schema rejected its own arguments: %v", err)
+	}
+	result, err := tool.Call(context.Background(), json.RawMessage(`{"input":"gophers"}`))
+	if err != nil || result.Content != "GOPHERS" {
+		t.Errorf("Call = %+v, %v", result, err)
+	}
+}
+
+func TestToolErrorsAreReportedToTheModel(t *testing.T) {
+	failing := NewTool("Fail", "Always fails", "anything", func(ctx context.Context, input string) (string, error) {
+		return "", errors.New("quota exceeded")
+	})
+	llm := &fakeLLM{replies: []string{"Function: Fail\nInput: x", "Function: Finish\nInput: gave up"}}
+	a := newTestAgent(llm, WithTools(failing))
+
+	if _, err := a.Run("hello"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := messages.Message{Type: messages.FunctionResult, Sender: "System", FunctionName: "Fail", Content: "Error: quota exceeded"}
+	if got := a.MessageHistory.GetMessages()[1]; got != want {
+		t.Errorf("history[1] = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// UnknownToolPolicy decides what happens when the model calls a function
+// that is not registered.
+type UnknownToolPolicy int
+
+const (
+	// UnknownToolReprompt tells the model which functions are valid and lets it choose again.
+	UnknownToolReprompt UnknownToolPolicy = iota
+	// UnknownToolFuzzyMatch calls the closest registered tool if the name is
+	// a near miss, such as a typo or different casing, and reprompts otherwise.
+	UnknownToolFuzzyMatch
+)
+
+// WithUnknownToolPolicy sets how calls to unregistered functions are handled.
+func WithUnknownToolPolicy(policy UnknownToolPolicy) AgentOption {
+	return func(a *Agent) {
+		a.UnknownTools = policy
+	}
+}
+
+// resolveUnknownTool applies the agent's policy to a call of an unregistered
+// function and records an EventUnknownTool either way.
+func (a *Agent) resolveUnknownTool(action Action) (Tool, bool) {
+	event := Event{
+		Type:     EventUnknownTool,
+		Function: action.Function,
+		Input:    action.Input,
+	}
+	if a.UnknownTools == UnknownToolFuzzyMatch {
+		if tool, found := fuzzyFindTool(a.Tools, action.Function); found {
+			event.Resolved = tool.Name()
+			event.Message = fmt.Sprintf("Function %s not found, using %s", action.Function, tool.Name())
+			a.recordEvent(event)
+			return tool, true
+		}
+	}
+	event.Message = fmt.Sprintf("Function %s not found, valid functions are %s", action.Function, strings.Join(a.Tools.Names(), ", "))
+	a.recordEvent(event)
+	return nil, false
+}
+
+// fuzzyFindTool returns the tool whose name is closest to name, if the edit
+// distance between their normalized forms is small enough to be a typo.
+func fuzzyFindTool(registry *ToolRegistry, name string) (Tool, bool) {
+	target := normalizeToolName(name)
+	if target == "" {
+		return nil, false
+	}
+	var best Tool
+	bestDistance, ties := -1, 0
+	for _, tool := range registry.List() {
+		distance := levenshtein(target, normalizeToolName(tool.Name()))
+		switch {
+		case bestDistance < 0 || distance < bestDistance:
+			best, bestDistance, ties = tool, distance, 0
+		case distance == bestDistance:
+			ties++
+		}
+	}
+	maxDistance := len([]rune(target)) / 4
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+	if best == nil || ties > 0 || bestDistance > maxDistance {
+		return nil, false
+	}
+	return best, true
+}
+
+// normalizeToolName lowercases name and drops everything but letters and digits,
+// so "current_time" and "CurrentTime" compare equal.
+func normalizeToolName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+)
+
+func TestFuzzyFindTool(t *testing.T) {
+	registry, err := NewToolRegistry(echoTool("Search"), echoTool("Browse"), echoTool("CurrentTime"), echoTool("Finish"), echoTool("Fetch1"), echoTool("Fetch2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"current_time", "CurrentTime"},
+		{"Curent Time", "CurrentTime"},
+		{"serach", ""}, // two edits in a six letter name
+		{"Serch", "Search"},
+		{"BROWSE", "Browse"},
+		{"Fetch3", ""}, // equally close to Fetch1 and Fetch2
+		{"Summarize", ""},
+		{"--", ""},
+	}
+	for _, tt := range tests {
+		tool, found := fuzzyFindTool(registry, tt.name)
+		got := ""
+		if found {
+			got = tool.Name()
+		}
+		if got != tt.want {
+			t.Errorf("fuzzyFindTool(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"grüße", "grusse", 3},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUnknownToolsAreRepromptedByDefault(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"Function: Serch\nInput: x", "Function: Finish\nInput: done"}}
+	a := newTestAgent(llm, WithTools(echoTool("Search")))
+
+	if _, err := a.Run("hello"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	reply := a.MessageHistory.GetMessages()[1]
+	if reply.Type != messages.FunctionResult || !strings.Contains(reply.Content, "Function Serch does not exist") {
+		t.Errorf("history[1] = %+v, want an error naming the unknown function", reply)
+	}
+	events := a.Events()
+	if len(events) != 1 || events[0].Type != EventUnknownTool || events[0].Resolved != "" || events[0].Step != 1 {
+		t.Errorf("events = %+v, want one unresolved unknown tool event at step 1", events)
+	}
+}
+
+func TestFuzzyMatchCallsTheClosestTool(t *testing.T) {
+	llm := &fakeLLM{replies: []string{"Function: Serch\nInput: gophers", "Function: Finish\nInput: done"}}
+	var handled []Event
+	a := newTestAgent(llm,
+		WithTools(echoTool("Search")),
+		WithUnknownToolPolicy(UnknownToolFuzzyMatch),
+		WithEventHandler(func(e Event) { handled = append(handled, e) }),
+	)
+
+	if _, err := a.Run("hello"); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := messages.Message{Type: messages.FunctionResult, Sender: "System", FunctionName: "Search", Content: "gophers"}
+	if got := a.MessageHistory.GetMessages()[1]; got != want {
+		t.Errorf("history[1] = %+v, want %+v", got, want)
+	}
+	if len(handled) != 1 || handled[0].Resolved != "Search" || handled[0].Function != "Serch" {
+		t.Errorf("handled events = %+v, want Serch resolved to Search", handled)
+	}
+}
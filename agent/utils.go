@@ -1,26 +1,14 @@
 package agent
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/chromedp/cdproto/cdp"
-	"github.com/chromedp/cdproto/dom"
-	"github.com/chromedp/cdproto/network"
-	"github.com/chromedp/chromedp"
-	"github.com/chromedp/chromedp/device"
 	"github.com/joho/godotenv"
-
-	serpapi "github.com/serpapi/google-search-results-golang"
 )
 
 // const MODEL_NAME = "zephyr"
@@ -29,19 +17,6 @@ const MODEL_NAME = "neural-chat"
 // const MODEL_NAME = "orca-mini:13b"
 const LOG_MODEL = true
 
-type GenerateRequest struct {
-	Model  string `json:"model,omitempty"`
-	System string `json:"system,omitempty"`
-	Prompt string `json:"prompt,omitempty"`
-	Raw    bool   `json:"raw,omitempty"`
-	Stream bool   `json:"stream"`
-}
-
-type GenerateResponse struct {
-	Response string `json:"response"`
-	Done     bool   `json:"done"`
-}
-
 func loadEnv() {
 	err := godotenv.Load()
 	if err != nil {
@@ -62,92 +37,48 @@ func safeString(m map[string]interface{}, key string) string {
 	return str
 }
 
+// Search runs a web search for text.
 func Search(text string) string {
-	parameter := map[string]string{
-		"q": text,
-	}
-
-	serpAPIKey := os.Getenv("SERP_API_KEY")
-	if serpAPIKey == "" {
-		log.Fatal("SERP_API_KEY is not set in the environment")
-	}
+	return SearchContext(context.Background(), text)
+}
 
-	search := serpapi.NewGoogleSearch(parameter, serpAPIKey)
-	data, err := search.GetJSON()
+// SearchContext is like Search but gives up when ctx is done.
+func SearchContext(ctx context.Context, text string) string {
+	result, err := searchWeb(ctx, text)
 	if err != nil {
 		return err.Error()
 	}
+	return result
+}
 
-	results := data["organic_results"].([]interface{})
-	var allResults strings.Builder
-
-	for _, r := range results {
-		result, ok := r.(map[string]interface{})
-		if !ok {
-			// Handle the case where the type assertion fails
-			continue
-		}
-		title := safeString(result, "title")
-		link := safeString(result, "link")
-		snippet := safeString(result, "snippet")
-
-		allResults.WriteString(fmt.Sprintf("Title: %s\nLink: %s\nSnippet: %s\n\n", title, link, snippet))
-	}
-
-	return allResults.String()
-
+// searchWeb searches the web with SerpAPI.
+func searchWeb(ctx context.Context, text string) (string, error) {
+	result, err := SearchTool().Call(ctx, argumentsFromInput(text))
+	return result.Content, err
 }
 
+// Browse returns the DOM tree of the page at url rendered by headless Chrome.
 func Browse(url string) string {
+	return BrowseContext(context.Background(), url)
+}
 
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.DisableGPU,
-		chromedp.UserDataDir("./tmp/user"),
-	)
-	allocatorCtx, allocatorCancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	defer allocatorCancel()
-	ctx, cancel := chromedp.NewContext(allocatorCtx)
-	defer cancel()
-
-	var nodes []*cdp.Node
-
-	var sb strings.Builder
-	var b1 []byte
-	err := chromedp.Run(ctx,
-		chromedp.Emulate(device.IPhone13),
-		chromedp.Navigate(url),
-		chromedp.WaitVisible(`body`, chromedp.ByQuery),
-		chromedp.Nodes(`html`, &nodes, chromedp.ByQuery),
-		chromedp.ActionFunc(func(c context.Context) error {
-			return dom.RequestChildNodes(nodes[0].NodeID).WithDepth(-1).Do(c)
-		}),
-		chromedp.Sleep(1*time.Second),
-		chromedp.CaptureScreenshot(&b1),
-		chromedp.ActionFunc(func(c context.Context) error {
-			printNodes(&sb, nodes, "", "  ", 20)
-			return nil
-		}),
-		chromedp.ActionFunc(func(ctx context.Context) error {
-			cookies, err := network.GetCookies().Do(ctx)
-			if err != nil {
-				return err
-			}
-			// for i, cookie := range cookies {
-			// 	log.Printf("chrome cookie %d: %+v", i, cookie.Name)
-			// }
-			log.Println(len(cookies))
-			return nil
-		}),
-	)
+// BrowseContext is like Browse but the Chrome session is bound to ctx.
+func BrowseContext(ctx context.Context, url string) string {
+	result, err := browsePage(ctx, url)
 	if err != nil {
-
-		log.Println(err.Error())
 		return err.Error()
 	}
-	if err := os.WriteFile("./tmp/screenshot1.png", b1, 0o644); err != nil {
+	return result
+}
+
+// browsePage renders url in headless Chrome from the shared browser pool and dumps its DOM tree.
+func browsePage(ctx context.Context, url string) (string, error) {
+	content, err := defaultBrowserPool().Fetch(ctx, url)
+	if err != nil {
 		log.Println(err.Error())
+		return "", err
 	}
-	return sb.String()
+	return content, nil
 }
 
 func printNodes(w *strings.Builder, nodes []*cdp.Node, padding, indent string, depth int) {
@@ -217,223 +148,25 @@ func printNodes(w *strings.Builder, nodes []*cdp.Node, padding, indent string, d
 	}
 }
 
-func parseOutput(text string) (functionName, input, reasoning string, err error) {
-	lines := strings.Split(text, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "Function:") {
-			functionName = strings.TrimSpace(strings.TrimPrefix(line, "Function:"))
-		} else if strings.HasPrefix(line, "Input:") {
-			input = strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "Input:")), "\"")
-		} else if strings.HasPrefix(line, "Reasoning:") {
-			reasoning = strings.TrimSpace(strings.TrimPrefix(line, "Reasoning:"))
-			break // Stop after finding the first complete function
-		}
-	}
-	if functionName == "" {
-		err = fmt.Errorf("missing required fields in output. Output <<<<<<%s>>>>>", text)
-	}
-	return
-}
-
-func PullModel() error {
-	ollamaEndpoint := os.Getenv("OLLAMA_ENDPOINT") // Replace with your actual endpoint
-	pullEndpoint := fmt.Sprintf("%s/api/pull", ollamaEndpoint)
-	log.Printf("URL: %v", pullEndpoint)
-	modelName := MODEL_NAME
-	stream := false
-	// Define the request body
-	requestBody := struct {
-		Name   string `json:"name"`
-		Stream bool   `json:"stream"`
-	}{
-		Name:   modelName,
-		Stream: stream,
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", pullEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 && resp.StatusCode > 299 {
-		return errors.New(fmt.Sprintf("API Pull Error code: %d", resp.StatusCode))
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	var response struct {
-		Status string `json:"status"`
-	}
-	err = json.Unmarshal(body, &response)
-	if err != nil {
-		return err
-	}
-
-	log.Println(response)
-
-	if response.Status != "success" {
-		return errors.New("request did not return success status")
-	}
-
-	return nil
-}
-
-func callAPI(system string, prompt string) (*GenerateResponse, error) {
-	ollamaEndpoint := os.Getenv("OLLAMA_ENDPOINT")
-	generateEndpoint := fmt.Sprintf("%s/api/generate", ollamaEndpoint)
-
-	requestBody := &GenerateRequest{
-		Model:  MODEL_NAME,
-		System: system,
-		Prompt: prompt,
-		Raw:    false,
-		Stream: false,
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequest("POST", generateEndpoint, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 && resp.StatusCode > 299 {
-		return nil, errors.New(fmt.Sprintf("API Error code: %d", resp.StatusCode))
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var generateResp GenerateResponse
-	err = json.Unmarshal(body, &generateResp)
-	if err != nil {
-		log.Printf("error decoding response: %v", err)
-		if e, ok := err.(*json.SyntaxError); ok {
-			log.Printf("syntax error at byte offset %d", e.Offset)
-		}
-		log.Printf("Response: %q", body)
-		if string(body) == "error code: 524" {
-			return callAPI(system, prompt)
-		}
-		return nil, err
-	}
-
-	log.Printf("\n------------\nSystem:\n%s\nPrompt:\n%s\nOutput:\n%s\n------------\n", system, prompt, generateResp.Response)
-
-	return &generateResp, nil
-}
-
-// splitIntoChunks splits a text into chunks of a specified length.
-func splitIntoChunks(text string, chunkSize int) []string {
-	var chunks []string
-	for len(text) > 0 {
-		if len(text) < chunkSize {
-			chunks = append(chunks, text)
-			break
-		}
-
-		chunk := text[:chunkSize]
-		chunks = append(chunks, chunk)
-		text = text[chunkSize:]
-	}
-
-	return chunks
-}
-
-func inferPrompt(input string) string {
+func (a *Agent) inferPrompt(ctx context.Context, input string) string {
 	systemText := "Analyze the user's original intent and reformulate it into a well-structured, single-paragraph input. This input should clearly outline the task requirements, how the output should be written and specify the criteria for successful completion by an AI system, based on the following provided text:"
-	// prompt := fmt.Sprintf("<|system|>%s</s><|user|>%s</s><|assistant|>", systemText, input)
-	response, err := callAPI(systemText, input)
+	response, err := a.generate(ctx, StageInferPrompt, LLMRequest{System: systemText, Prompt: input})
 	if err != nil {
 		return input
 	}
 	return response.Response
 }
 
-func restructureOutput(input string) string {
+func (a *Agent) restructureOutput(ctx context.Context, input string) (string, error) {
 	systemText := "Restructure output as follows:\nFunction: name of the function\nInput: Funtion Input\nReasoning: Why this function is selected\nCritism: A critic of this action\n"
-	// prompt := fmt.Sprintf("<|system|>%s</s><|user|>%s</s><|assistant|>", systemText, input)
-	response, err := callAPI(systemText, input)
-	if err != nil {
-		return input
+	if a.ActionFormat == ActionFormatJSON {
+		systemText = "Restructure output as a single JSON object with the fields:\n" + jsonActionFormat
 	}
-	return response.Response
-}
-
-func summarizeWebPage(topic, input string) string {
-	texts := splitIntoChunks(input, 1000)
-	previous := ""
-	isRelated := false
-	for i, text := range texts {
-		prompt := fmt.Sprintf(`"For the topic '%s', please prograsively extract essential information from the given web page and previous extract, 
-		concentrating on the main body text, headings, and significant hyperlinks. 
-		Summarize the central themes or key information related to the specified topic, 
-		ensuring the summary is succinct and directly relevant. 
-		Exclude any details about website technologies or unrelated content. 
-		Additionally, identify if the content is relevant to the given topic. 
-		Provide a list of the most pertinent links for additional reading or context, disregarding cookie and consent notices. 
-		The output should be formatted as follows:
-
-		Content: [Concise summary focusing on the topic]
-		IsRelated: [Yes/No, based on relevance to the topic]
-		Links: [Relevant links for further information]
-		- Link 1
-		- Link 2
-
-		Previous Extract:
-		%s
-
-		WebPage as input:"`, topic, previous)
-		response, err := callAPI(prompt, text)
-		if err != nil {
-			if len(previous) > 0 {
-				return previous
-			}
-			return err.Error()
-		}
-		previous = response.Response
-		isRelated = findIsRelatedStatus(previous)
-		if !isRelated {
-			return previous
-		}
-		// log.Printf("Iteration %d Summary: %s", i, previous)
-		if i > 5 {
-			break
-		}
+	response, err := a.generate(ctx, StageAction, LLMRequest{System: systemText, Prompt: input, Format: a.responseFormat()})
+	if err != nil {
+		return "", err
 	}
-	return previous
+	return response.Response, nil
 }
 
 func findIsRelatedStatus(text string) bool {
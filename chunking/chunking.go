@@ -0,0 +1,214 @@
+// Package chunking splits text into pieces that fit a token budget.
+package chunking
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bgokden/miniagent/prompt"
+)
+
+// CountFunc returns the number of tokens in s.
+type CountFunc func(s string) (int, error)
+
+// Options configures Split.
+type Options struct {
+	MaxTokens int       // Maximum tokens per chunk, required
+	Overlap   int       // Tokens repeated from the end of a chunk at the start of the next
+	Counter   CountFunc // Token counter, defaults to the prompt package tokenizer
+}
+
+// Boundaries tried in order of preference: paragraph, sentence, word.
+var boundaries = []*regexp.Regexp{
+	regexp.MustCompile(`\n[ \t]*\n\s*`),
+	regexp.MustCompile(`[.!?。！？]+["'”’)\]]*\s+`),
+	regexp.MustCompile(`\s+`),
+}
+
+// Split splits text into chunks of at most opts.MaxTokens tokens.
+// It cuts between paragraphs where possible, then between sentences, then
+// between words, and only splits inside a word when a single word is too
+// long. Chunks are substrings of text in order and cuts always fall on rune
+// boundaries; stretches of text holding only whitespace may be dropped.
+func Split(text string, opts Options) ([]string, error) {
+	if opts.MaxTokens <= 0 {
+		return nil, fmt.Errorf("chunking: MaxTokens must be positive")
+	}
+	if opts.Overlap < 0 || opts.Overlap >= opts.MaxTokens {
+		return nil, fmt.Errorf("chunking: Overlap must be between 0 and MaxTokens")
+	}
+	if opts.Counter == nil {
+		opts.Counter = prompt.CountTokens
+	}
+	s := &splitter{opts: opts}
+	chunks := s.split(text, 0)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return chunks, nil
+}
+
+type splitter struct {
+	opts Options
+	err  error
+}
+
+func (s *splitter) count(text string) int {
+	if s.err != nil {
+		return 0
+	}
+	n, err := s.opts.Counter(text)
+	if err != nil {
+		s.err = err
+	}
+	return n
+}
+
+func (s *splitter) split(text string, level int) []string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if s.count(text) <= s.opts.MaxTokens || s.err != nil {
+		return []string{text}
+	}
+	if level == len(boundaries) {
+		return s.splitRunes(text)
+	}
+
+	parts := splitAfter(text, boundaries[level])
+	var chunks []string
+	var current []string // Parts of the chunk being built
+	var sizes []int      // Tokens of each part in current
+	total := 0           // Tokens in current, summed over its parts or recounted as a whole
+	carried := 0         // Leading parts of current repeated from the previous chunk
+
+	// flush ends the chunk in current and returns how many of its trailing
+	// parts were handed back to be placed again. The running total only
+	// estimates the joined parts, so the chunk is counted once as a whole
+	// and parts that push it past MaxTokens are handed back, or the carried
+	// overlap is dropped when a single new part is left.
+	flush := func(overlap bool) int {
+		if len(current) == 0 {
+			return 0
+		}
+		back := 0
+		chunk := strings.Join(current, "")
+		for len(current) > 1 && s.count(chunk) > s.opts.MaxTokens {
+			if len(current) == carried+1 {
+				current, sizes = current[carried:], sizes[carried:]
+			} else {
+				back++
+				current, sizes = current[:len(current)-1], sizes[:len(sizes)-1]
+			}
+			chunk = strings.Join(current, "")
+		}
+		chunks = append(chunks, chunk)
+		current, sizes, total = s.carry(current, sizes, overlap)
+		carried = len(current)
+		return back
+	}
+
+	for i := 0; ; i++ {
+		if i == len(parts) {
+			back := flush(false)
+			if back == 0 {
+				break
+			}
+			i -= back + 1
+			continue
+		}
+		part := parts[i]
+		n := s.count(part)
+		if n > s.opts.MaxTokens {
+			if back := flush(false); back > 0 {
+				i -= back + 1
+				continue
+			}
+			chunks = append(chunks, s.split(part, level+1)...)
+			continue
+		}
+		if !s.fits(current, &total, part, n) {
+			if back := flush(s.opts.Overlap > 0); back > 0 {
+				i -= back + 1
+				continue
+			}
+			if !s.fits(current, &total, part, n) {
+				current, sizes, total, carried = nil, nil, n, 0
+			}
+		}
+		current, sizes = append(current, part), append(sizes, n)
+	}
+	return chunks
+}
+
+// fits reports whether part, of n tokens, can join current, and then adds it
+// to total. The running total is trusted while it stays within MaxTokens;
+// past it the joined text is recounted as a whole, since tokens may merge
+// across the cut.
+func (s *splitter) fits(current []string, total *int, part string, n int) bool {
+	if len(current) == 0 {
+		*total = n
+		return true
+	}
+	if *total+n <= s.opts.MaxTokens {
+		*total += n
+		return true
+	}
+	joined := s.count(strings.Join(current, "") + part)
+	if joined > s.opts.MaxTokens {
+		return false
+	}
+	*total = joined
+	return true
+}
+
+// carry returns the trailing parts of a finished chunk to repeat at the start
+// of the next one, up to Overlap tokens, with their sizes and token total.
+func (s *splitter) carry(current []string, sizes []int, overlap bool) ([]string, []int, int) {
+	if !overlap {
+		return nil, nil, 0
+	}
+	start, total := len(current), 0
+	for start > 0 && total+sizes[start-1] <= s.opts.Overlap {
+		start--
+		total += sizes[start]
+	}
+	// As in flush, the summed sizes are checked against the joined text.
+	for ; start < len(current); start++ {
+		if total = s.count(strings.Join(current[start:], "")); total <= s.opts.Overlap {
+			break
+		}
+	}
+	if start == len(current) {
+		return nil, nil, 0
+	}
+	return append([]string(nil), current[start:]...), append([]int(nil), sizes[start:]...), total
+}
+
+// splitRunes halves text at rune boundaries until every part fits.
+func (s *splitter) splitRunes(text string) []string {
+	if s.count(text) <= s.opts.MaxTokens || utf8.RuneCountInString(text) < 2 || s.err != nil {
+		return []string{text}
+	}
+	runes := []rune(text)
+	middle := len(runes) / 2
+	return append(s.splitRunes(string(runes[:middle])), s.splitRunes(string(runes[middle:]))...)
+}
+
+// splitAfter splits text after every match of sep, keeping sep with the preceding part.
+func splitAfter(text string, sep *regexp.Regexp) []string {
+	var parts []string
+	start := 0
+	for _, match := range sep.FindAllStringIndex(text, -1) {
+		if match[1] > start {
+			parts = append(parts, text[start:match[1]])
+			start = match[1]
+		}
+	}
+	if start < len(text) {
+		parts = append(parts, text[start:])
+	}
+	return parts
+}
@@ -0,0 +1,164 @@
+package chunking
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/bgokden/miniagent/prompt"
+)
+
+var heuristic = prompt.HeuristicCounter{}.CountTokens
+
+// words counts whitespace separated words.
+func words(s string) (int, error) {
+	return len(strings.Fields(s)), nil
+}
+
+func checkChunks(t *testing.T, chunks []string, opts Options) {
+	t.Helper()
+	for i, chunk := range chunks {
+		n, _ := opts.Counter(chunk)
+		if n > opts.MaxTokens {
+			t.Errorf("chunk %d has %d tokens, more than %d: %q", i, n, opts.MaxTokens, chunk)
+		}
+		if !utf8.ValidString(chunk) {
+			t.Errorf("chunk %d is not valid UTF-8: %q", i, chunk)
+		}
+	}
+}
+
+func TestSplitPrefersParagraphs(t *testing.T) {
+	text := "one two three.\n\nfour five six.\n\nseven eight nine."
+	opts := Options{MaxTokens: 6, Counter: words}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"one two three.\n\nfour five six.\n\n", "seven eight nine."}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+}
+
+func TestSplitFallsBackToSentencesAndWords(t *testing.T) {
+	text := "First sentence has five words. Second one is short! " + strings.Repeat("word ", 12)
+	opts := Options{MaxTokens: 5, Counter: words}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, chunks, opts)
+	if chunks[0] != "First sentence has five words. " {
+		t.Errorf("first chunk = %q, want the first sentence", chunks[0])
+	}
+	if strings.Join(chunks, "") != text {
+		t.Errorf("chunks do not cover the text: %q", chunks)
+	}
+}
+
+func TestSplitLongWordsAtRuneBoundaries(t *testing.T) {
+	text := strings.Repeat("äöü", 40)
+	opts := Options{MaxTokens: 5, Counter: heuristic}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, chunks, opts)
+	if len(chunks) < 2 || strings.Join(chunks, "") != text {
+		t.Errorf("chunks = %q", chunks)
+	}
+}
+
+func TestSplitRecountsJoinedChunks(t *testing.T) {
+	// Two words count as 2 tokens but ten as 12, so summing the tokens of
+	// the parts would let chunks grow past the limit.
+	superadditive := func(s string) (int, error) {
+		return len(strings.Fields(s)) * 6 / 5, nil
+	}
+	text := strings.Repeat("Go fast. ", 20)
+	opts := Options{MaxTokens: 10, Counter: superadditive}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, chunks, opts)
+	if strings.Join(chunks, "") != text {
+		t.Errorf("chunks do not cover the text: %q", chunks)
+	}
+}
+
+func TestSplitRecountsJoinedOverlap(t *testing.T) {
+	superadditive := func(s string) (int, error) {
+		return len(strings.Fields(s)) * 6 / 5, nil
+	}
+	text := strings.Repeat("Go fast. ", 20)
+	opts := Options{MaxTokens: 10, Overlap: 4, Counter: superadditive}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, chunks, opts)
+	if len(chunks) < 2 || !strings.HasPrefix(text, chunks[0]) || !strings.HasSuffix(text, chunks[len(chunks)-1]) {
+		t.Errorf("chunks = %q", chunks)
+	}
+}
+
+func TestSplitCountsIncrementally(t *testing.T) {
+	// Recounting the whole chunk for every word counts the text about 75
+	// times over here.
+	var counted int
+	counter := func(s string) (int, error) {
+		counted += len(s)
+		return heuristic(s)
+	}
+	text := strings.Repeat("gophers dig tunnels ", 3000)
+	opts := Options{MaxTokens: 200, Overlap: 20, Counter: counter}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, chunks, opts)
+	if counted > 20*len(text) {
+		t.Errorf("counted %d bytes for %d bytes of text", counted, len(text))
+	}
+}
+
+func TestSplitOverlap(t *testing.T) {
+	text := "a1 a2. b1 b2. c1 c2. d1 d2. e1 e2."
+	opts := Options{MaxTokens: 4, Overlap: 2, Counter: words}
+	chunks, err := Split(text, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, chunks, opts)
+	want := []string{"a1 a2. b1 b2. ", "b1 b2. c1 c2. ", "c1 c2. d1 d2. ", "d1 d2. e1 e2."}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("chunks = %q, want %q", chunks, want)
+	}
+}
+
+func TestSplitSmallAndEmptyText(t *testing.T) {
+	opts := Options{MaxTokens: 10, Counter: words}
+	if chunks, err := Split("fits in one", opts); err != nil || len(chunks) != 1 || chunks[0] != "fits in one" {
+		t.Errorf("Split = %q, %v", chunks, err)
+	}
+	if chunks, err := Split(" \n\n\t", opts); err != nil || chunks != nil {
+		t.Errorf("whitespace = %q, %v, want no chunks", chunks, err)
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	if _, err := Split("text", Options{Counter: words}); err == nil {
+		t.Error("MaxTokens 0 accepted")
+	}
+	if _, err := Split("text", Options{MaxTokens: 2, Overlap: 2, Counter: words}); err == nil {
+		t.Error("Overlap equal to MaxTokens accepted")
+	}
+	counterErr := errors.New("tokenizer broke")
+	failing := func(string) (int, error) { return 0, counterErr }
+	if _, err := Split("text", Options{MaxTokens: 2, Counter: failing}); !errors.Is(err, counterErr) {
+		t.Errorf("error = %v, want the counter error", err)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
 
 	"github.com/bgokden/miniagent/agent"
+	"github.com/bgokden/miniagent/prompt"
 	"github.com/joho/godotenv"
 )
 
@@ -16,16 +21,25 @@ func loadEnv() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		if err := explain(os.Stdout, os.Args[2:]); err != nil {
+			log.Fatalf("Error explaining prompt: %s", err.Error())
+		}
+		return
+	}
+
 	loadEnv()
 
-	err_pull := agent.PullModel()
+	userInput := "Create a list of VCs in the Netherlands."
+
+	anAgent := agent.NewAgent()
+	defer anAgent.Close()
+
+	err_pull := anAgent.PullModel()
 	if err_pull != nil {
 		log.Printf("Error pulling model: %s", err_pull.Error())
 	}
 
-	userInput := "Create a list of VCs in the Netherlands."
-
-	anAgent := agent.NewAgent()
 	result, err := anAgent.Run(userInput)
 	if err != nil {
 		log.Printf("Error from agent: %s", err.Error())
@@ -34,3 +48,73 @@ func main() {
 	fmt.Println(result)
 
 }
+
+// explain writes the prompt tree of an agent configuration with the tokens
+// each node contributes for an input to w, followed by the assembled prompt.
+//
+//	miniagent explain [-tree file] [-max-length n] [-model name] [-tokenizer file | -estimate] [-format text|json] [-concurrency n] input...
+func explain(w io.Writer, args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	treeFile := flags.String("tree", "", "prompt tree YAML or JSON file, the built-in tree if empty")
+	maxLength := flags.Int("max-length", 0, "prompt token budget, the agent default if 0")
+	model := flags.String("model", "", "model name, used to pick a matching tokenizer")
+	tokenizerFile := flags.String("tokenizer", "", "local tokenizer.json to count tokens with")
+	estimate := flags.Bool("estimate", false, "estimate tokens without a tokenizer")
+	format := flags.String("format", "text", "action format, text or json")
+	concurrency := flags.Int("concurrency", 0, "prompt tree generators to run at the same time")
+	showPrompt := flags.Bool("prompt", true, "print the assembled prompt")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	// The environment is optional when nothing is sent to a model.
+	_ = godotenv.Load()
+
+	options := []agent.AgentOption{}
+	if *treeFile != "" {
+		options = append(options, agent.WithPromptTreeFile(*treeFile))
+	}
+	if *maxLength > 0 {
+		options = append(options, agent.WithMaxLength(*maxLength))
+	}
+	if *model != "" {
+		options = append(options, agent.WithModel(*model))
+	}
+	if *concurrency > 0 {
+		options = append(options, agent.WithPromptConcurrency(*concurrency))
+	}
+	switch {
+	case *estimate:
+		options = append(options, agent.WithTokenCounter(prompt.HeuristicCounter{}))
+	case *tokenizerFile != "":
+		counter, err := prompt.NewFileTokenCounter(*tokenizerFile)
+		if err != nil {
+			return err
+		}
+		options = append(options, agent.WithTokenCounter(counter))
+	case *model != "":
+		options = append(options, agent.WithTokenCounter(prompt.ModelTokenCounter(*model)))
+	}
+	switch *format {
+	case "text":
+	case "json":
+		options = append(options, agent.WithActionFormat(agent.ActionFormatJSON))
+	default:
+		return fmt.Errorf("unknown action format %q", *format)
+	}
+
+	anAgent := agent.NewAgent(options...)
+	defer anAgent.Close()
+
+	report, err := anAgent.ExplainPrompt(strings.Join(flags.Args(), " "))
+	if err != nil {
+		return err
+	}
+	if err := report.WriteTree(w, anAgent.PromptTree); err != nil {
+		return err
+	}
+	if *showPrompt {
+		fmt.Fprintln(w, "------------------------------------")
+		fmt.Fprintln(w, report.Prompt)
+	}
+	return nil
+}
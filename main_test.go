@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	var out strings.Builder
+	if err := explain(&out, []string{"-estimate", "-max-length", "2000", "find", "gophers"}); err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	for _, want := range []string{"NODE", "├── system", "│   └── functions", "total", "------------------------------------\nYou are an AI Assistant."} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output misses %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := explain(&out, []string{"-estimate", "-tree", "prompts/default.yaml", "-prompt=false", "-format", "json", "find gophers"}); err != nil {
+		t.Fatalf("explain with a tree file: %v", err)
+	}
+	if strings.Contains(out.String(), "You are an AI Assistant.") || !strings.Contains(out.String(), "└── memories") {
+		t.Errorf("output =\n%s", out.String())
+	}
+}
+
+func TestExplainErrors(t *testing.T) {
+	tests := map[string][]string{
+		"unknown format":    {"-estimate", "-format", "xml", "input"},
+		"missing tree file": {"-estimate", "-tree", "missing.yaml", "input"},
+		"missing tokenizer": {"-tokenizer", "missing.json", "input"},
+	}
+	for name, args := range tests {
+		if err := explain(&strings.Builder{}, args); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -45,15 +45,58 @@ func (m *MessageHistory) AddMessage(msgType MessageType, sender, functionName, c
 	})
 }
 
+// GetMessages returns a copy of all messages in the history.
+func (m *MessageHistory) GetMessages() []Message {
+	result := make([]Message, len(m.messages))
+	copy(result, m.messages)
+	return result
+}
+
+// Recent returns the newest of msgs that fit in maxTokens as measured by
+// count, leaving out the oldest ones. Messages are measured as rendered by
+// String. The newest message is always included so the caller can cut it
+// down if it alone is too long.
+func Recent(msgs []Message, maxTokens int, count func(string) (int, error)) ([]Message, error) {
+	start, used := len(msgs), 0
+	for start > 0 {
+		n, err := count(msgs[start-1].String())
+		if err != nil {
+			return nil, err
+		}
+		if used+n > maxTokens && start < len(msgs) {
+			break
+		}
+		used += n
+		start--
+	}
+	return msgs[start:], nil
+}
+
+// LastHumanContent returns the content of the newest human message in msgs,
+// and false if there is none.
+func LastHumanContent(msgs []Message) (string, bool) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Type == HumanMessage {
+			return msgs[i].Content, true
+		}
+	}
+	return "", false
+}
+
+// String renders the message as a line of the conversation.
+func (msg Message) String() string {
+	prefix := fmt.Sprintf("%s: ", msg.Sender)
+	if msg.FunctionName != "" {
+		prefix += fmt.Sprintf("[%s] ", msg.FunctionName)
+	}
+	return fmt.Sprintf("%s%s\n", prefix, msg.Content)
+}
+
 // GetAllMessagesAsString returns all messages in the history as a single string.
 func (m *MessageHistory) GetAllMessagesAsString() string {
 	var result strings.Builder
 	for _, msg := range m.messages {
-		prefix := fmt.Sprintf("%s: ", msg.Sender)
-		if msg.FunctionName != "" {
-			prefix += fmt.Sprintf("[%s] ", msg.FunctionName)
-		}
-		result.WriteString(fmt.Sprintf("%s%s\n", prefix, msg.Content))
+		result.WriteString(msg.String())
 	}
 	return result.String()
 }
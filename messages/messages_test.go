@@ -0,0 +1,58 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+)
+
+func words(s string) (int, error) {
+	return len(strings.Fields(s)), nil
+}
+
+func TestMessageString(t *testing.T) {
+	msg := Message{Type: FunctionResult, Sender: "System", FunctionName: "Search", Content: "Gophers dig."}
+	if got := msg.String(); got != "System: [Search] Gophers dig.\n" {
+		t.Errorf("String = %q", got)
+	}
+	history := NewMessageHistory()
+	history.AddMessage(HumanMessage, "Human", "", "Find gophers")
+	history.AddMessage(FunctionResult, "System", "Search", "Gophers dig.")
+	if got := history.GetAllMessagesAsString(); got != "Human: Find gophers\nSystem: [Search] Gophers dig.\n" {
+		t.Errorf("GetAllMessagesAsString = %q", got)
+	}
+	msgs := history.GetMessages()
+	msgs[0].Content = "changed"
+	if history.GetMessages()[0].Content != "Find gophers" {
+		t.Error("GetMessages does not return a copy")
+	}
+}
+
+func TestRecent(t *testing.T) {
+	msgs := []Message{
+		{Sender: "Human", Content: "one two"},      // 3 words with the sender
+		{Sender: "AI", Content: "three four five"}, // 4
+		{Sender: "Human", Content: "six seven"},    // 3
+	}
+	tests := []struct {
+		maxTokens int
+		want      int
+	}{
+		{10, 3},
+		{9, 2},
+		{7, 2},
+		{6, 1},
+		{0, 1}, // the newest message is always kept
+	}
+	for _, tt := range tests {
+		got, err := Recent(msgs, tt.maxTokens, words)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want || got[len(got)-1] != msgs[2] {
+			t.Errorf("Recent(%d) = %v, want the newest %d", tt.maxTokens, got, tt.want)
+		}
+	}
+	if got, err := Recent(nil, 10, words); err != nil || len(got) != 0 {
+		t.Errorf("Recent(nil) = %v, %v", got, err)
+	}
+}
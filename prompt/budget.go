@@ -0,0 +1,301 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Truncation selects how a part that is over its token budget is cut down.
+type Truncation int
+
+const (
+	// TruncateEnd keeps the beginning of the part and cuts the end. It is the
+	// default.
+	TruncateEnd Truncation = iota
+	// TruncateStart keeps the end of the part and cuts the beginning, which
+	// drops the oldest lines of a conversation first.
+	TruncateStart
+	// TruncateMiddle keeps the beginning and the end of the part and replaces
+	// the middle with an ellipsis line.
+	TruncateMiddle
+	// TruncateSummarize replaces the part with the output of the summarizer
+	// set by WithSummarizer. Without a summarizer, or if the summary is still
+	// too long, it behaves like TruncateEnd.
+	TruncateSummarize
+	// TruncateDrop leaves the part out entirely.
+	TruncateDrop
+)
+
+// Summarizer shortens text to at most maxTokens tokens.
+type Summarizer func(text string, maxTokens int) (string, error)
+
+// ellipsis marks the text removed by TruncateMiddle.
+const ellipsis = "\n...\n"
+
+// budget returns the tokens node may use when remaining tokens are left in
+// the prompt and reserved of them are held for the MinTokens of nodes not yet
+// generated.
+func (node *FunctionNode) budget(remaining, reserved int) int {
+	limit := remaining - reserved
+	if own := minInt(node.MinTokens, remaining); limit < own {
+		limit = own
+	}
+	if node.MaxTokens > 0 && limit > node.MaxTokens {
+		limit = node.MaxTokens
+	}
+	return limit
+}
+
+// fit cuts part down to limit tokens with the truncation strategy of node and
+// returns it with its token count. The part is dropped if it would be cut
+// below the node's MinTokens.
+func (o *options) fit(node *FunctionNode, part string, limit int) (string, int, error) {
+	n, err := o.counter.CountTokens(part)
+	if err != nil {
+		return "", 0, err
+	}
+	if n <= limit {
+		return part, n, nil
+	}
+	cut, err := o.truncate(part, limit, node.Truncation)
+	if err != nil || cut == "" {
+		return "", 0, err
+	}
+	n, err = o.counter.CountTokens(cut)
+	if err != nil {
+		return "", 0, err
+	}
+	if n > limit || n < node.MinTokens {
+		return "", 0, nil
+	}
+	return cut, n, nil
+}
+
+// Truncate cuts s down to at most maxTokens tokens with strategy, measuring
+// and summarizing it as configured by opts. Text that fits is returned as is.
+func Truncate(s string, maxTokens int, strategy Truncation, opts ...Option) (string, error) {
+	o := newOptions(opts)
+	n, err := o.counter.CountTokens(s)
+	if err != nil {
+		return "", err
+	}
+	if n <= maxTokens {
+		return s, nil
+	}
+	return o.truncate(s, maxTokens, strategy)
+}
+
+// truncate cuts s down to at most limit tokens using strategy.
+func (o *options) truncate(s string, limit int, strategy Truncation) (string, error) {
+	if limit <= 0 {
+		return "", nil
+	}
+	switch strategy {
+	case TruncateDrop:
+		return "", nil
+	case TruncateStart:
+		return o.keepTail(s, limit)
+	case TruncateMiddle:
+		return o.keepEnds(s, limit)
+	case TruncateSummarize:
+		if o.summarizer != nil {
+			summary, err := o.summarizer(s, limit)
+			if err != nil {
+				return "", err
+			}
+			return o.keepHead(summary, limit)
+		}
+	}
+	return o.keepHead(s, limit)
+}
+
+// keepHead returns the longest prefix of s within limit tokens, preferring
+// to end it at a line or word boundary.
+func (o *options) keepHead(s string, limit int) (string, error) {
+	offsets := runeOffsets(s)
+	// Find the largest rune offset whose prefix fits.
+	lo, hi := 0, len(offsets)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		n, err := o.counter.CountTokens(s[:offsets[mid]])
+		if err != nil {
+			return "", err
+		}
+		if n <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	cut := offsets[lo]
+	if cut == len(s) {
+		return s, nil
+	}
+	if i := strings.LastIndexByte(s[:cut], '\n'); i >= cut/2 {
+		cut = i + 1
+	} else if i := strings.LastIndexAny(s[:cut], " \t"); i >= cut/2 {
+		cut = i + 1
+	}
+	return s[:cut], nil
+}
+
+// keepTail returns the longest suffix of s within limit tokens, preferring
+// to start it at a line or word boundary.
+func (o *options) keepTail(s string, limit int) (string, error) {
+	offsets := runeOffsets(s)
+	// Find the smallest rune offset whose suffix fits.
+	lo, hi := 0, len(offsets)-1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		n, err := o.counter.CountTokens(s[offsets[mid]:])
+		if err != nil {
+			return "", err
+		}
+		if n <= limit {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	cut := offsets[lo]
+	if cut == 0 {
+		return s, nil
+	}
+	// Searching from the byte before cut keeps a cut that already starts a
+	// line or word.
+	kept := len(s) - cut
+	if i := strings.IndexByte(s[cut-1:], '\n'); i >= 0 && i <= kept/2 {
+		cut += i
+	} else if i := strings.IndexAny(s[cut-1:], " \t"); i >= 0 && i <= kept/2 {
+		cut += i
+	}
+	return s[cut:], nil
+}
+
+// keepEnds keeps the beginning and the end of s within limit tokens, joined
+// by an ellipsis line.
+func (o *options) keepEnds(s string, limit int) (string, error) {
+	markerTokens, err := o.counter.CountTokens(ellipsis)
+	if err != nil {
+		return "", err
+	}
+	if limit <= 2*markerTokens {
+		return o.keepHead(s, limit)
+	}
+	headLimit := (limit - markerTokens) / 2
+	head, err := o.keepHead(s, headLimit)
+	if err != nil {
+		return "", err
+	}
+	tail, err := o.keepTail(s[len(head):], limit-markerTokens-headLimit)
+	if err != nil {
+		return "", err
+	}
+	// Tokens can merge across the joins, so check the result as a whole.
+	return o.keepHead(head+ellipsis+tail, limit)
+}
+
+// enforce joins parts, which are sorted by output order, and shrinks or drops
+// the last generated ones until the result fits in maxLength tokens. Token
+// counts of separate parts do not always add up to the count of their
+// concatenation, so the assembled prompt is measured again.
+func (o *options) enforce(parts []FunctionNodeOutput, maxLength int) (string, error) {
+	for {
+		text := joinOutputs(parts)
+		total, err := o.counter.CountTokens(text)
+		if err != nil {
+			return "", err
+		}
+		if total <= maxLength || text == "" {
+			return text, nil
+		}
+		victim := -1
+		for i := range parts {
+			if parts[i].output != "" && (victim < 0 || parts[i].step > parts[victim].step) {
+				victim = i
+			}
+		}
+		part := &parts[victim]
+		n, err := o.counter.CountTokens(part.output)
+		if err != nil {
+			return "", err
+		}
+		cut, _, err := o.fit(part.node, part.output, n-(total-maxLength))
+		if err != nil {
+			return "", err
+		}
+		if cut == part.output {
+			cut = ""
+		}
+		part.output = cut
+	}
+}
+
+// joinOutputs concatenates the outputs of parts.
+func joinOutputs(parts []FunctionNodeOutput) string {
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(part.output)
+	}
+	return b.String()
+}
+
+// runeOffsets returns the byte offset of every rune in s followed by len(s).
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, utf8.RuneCountInString(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var truncationNames = map[Truncation]string{
+	TruncateEnd:       "end",
+	TruncateStart:     "start",
+	TruncateMiddle:    "middle",
+	TruncateSummarize: "summarize",
+	TruncateDrop:      "drop",
+}
+
+// String returns the name of t as used in prompt tree files.
+func (t Truncation) String() string {
+	if name, ok := truncationNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Truncation(%d)", int(t))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t Truncation) MarshalText() ([]byte, error) {
+	if _, ok := truncationNames[t]; !ok {
+		return nil, fmt.Errorf("prompt: unknown truncation %d", int(t))
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts the names
+// end, start, middle, summarize and drop.
+func (t *Truncation) UnmarshalText(text []byte) error {
+	for value, name := range truncationNames {
+		if name == string(text) {
+			*t = value
+			return nil
+		}
+	}
+	return fmt.Errorf("prompt: unknown truncation %q", text)
+}
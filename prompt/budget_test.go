@@ -0,0 +1,161 @@
+package prompt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// wordCounter counts whitespace separated words.
+type wordCounter struct{}
+
+func (wordCounter) CountTokens(s string) (int, error) {
+	return len(strings.Fields(s)), nil
+}
+
+// superadditiveCounter counts five words as six tokens, so parts measured
+// on their own add up to less than their concatenation.
+type superadditiveCounter struct{}
+
+func (superadditiveCounter) CountTokens(s string) (int, error) {
+	return len(strings.Fields(s)) * 6 / 5, nil
+}
+
+func TestTruncate(t *testing.T) {
+	const text = "one two three four five six seven eight"
+	summarize := WithSummarizer(func(text string, maxTokens int) (string, error) {
+		return "a summary that is still too long", nil
+	})
+	tests := []struct {
+		name     string
+		strategy Truncation
+		limit    int
+		opts     []Option
+		want     string
+	}{
+		{"end", TruncateEnd, 3, nil, "one two three "},
+		{"start", TruncateStart, 3, nil, "six seven eight"},
+		{"middle", TruncateMiddle, 5, nil, "one two \n...\nseven eight"},
+		{"middle without room for the marker", TruncateMiddle, 2, nil, "one two "},
+		{"drop", TruncateDrop, 3, nil, ""},
+		{"summarize", TruncateSummarize, 3, []Option{summarize}, "a summary that "},
+		{"summarize without a summarizer", TruncateSummarize, 3, nil, "one two three "},
+		{"no tokens left", TruncateEnd, 0, nil, ""},
+		{"fits", TruncateDrop, 8, nil, text},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Truncate(text, tt.limit, tt.strategy, append(tt.opts, WithTokenCounter(wordCounter{}))...)
+			if err != nil || got != tt.want {
+				t.Errorf("Truncate = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncatePrefersLineBoundaries(t *testing.T) {
+	const text = "first line here\nsecond line here\nthird line here"
+	opts := WithTokenCounter(wordCounter{})
+	if got, _ := Truncate(text, 5, TruncateEnd, opts); got != "first line here\n" {
+		t.Errorf("end = %q", got)
+	}
+	if got, _ := Truncate(text, 5, TruncateStart, opts); got != "third line here" {
+		t.Errorf("start = %q", got)
+	}
+}
+
+func TestTruncateKeepsRunesWhole(t *testing.T) {
+	text := strings.Repeat("ü", 30)
+	got, err := Truncate(text, 5, TruncateEnd, WithTokenCounter(HeuristicCounter{}))
+	if err != nil || got == "" || !strings.HasPrefix(text, got) || len(got)%2 != 0 {
+		t.Errorf("Truncate = %q, %v", got, err)
+	}
+}
+
+func TestTruncateSummarizerError(t *testing.T) {
+	summaryErr := errors.New("model down")
+	_, err := Truncate("one two three", 1, TruncateSummarize, WithTokenCounter(wordCounter{}),
+		WithSummarizer(func(string, int) (string, error) { return "", summaryErr }))
+	if !errors.Is(err, summaryErr) {
+		t.Errorf("error = %v, want the summarizer error", err)
+	}
+}
+
+func TestNodeBudget(t *testing.T) {
+	tests := []struct {
+		name                string
+		min, max            int
+		remaining, reserved int
+		want                int
+	}{
+		{"everything left", 0, 0, 100, 0, 100},
+		{"reservations held back", 0, 0, 100, 30, 70},
+		{"capped by MaxTokens", 0, 40, 100, 0, 40},
+		{"MinTokens beats reservations", 50, 0, 100, 80, 50},
+		{"MinTokens is limited by what is left", 50, 0, 20, 80, 20},
+		{"nothing left", 0, 0, 10, 20, 0},
+	}
+	for _, tt := range tests {
+		node := &FunctionNode{MinTokens: tt.min, MaxTokens: tt.max}
+		if got := node.budget(tt.remaining, tt.reserved); got != tt.want {
+			t.Errorf("%s: budget = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnforceTrimsTheLastGeneratedPart(t *testing.T) {
+	o := newOptions([]Option{WithTokenCounter(superadditiveCounter{})})
+	// The second part is rendered first but generated last, so it gives way.
+	parts := []FunctionNodeOutput{
+		{node: &FunctionNode{ID: "late"}, output: "a b c d e ", order: 0, step: 1},
+		{node: &FunctionNode{ID: "early"}, output: "f g h i j ", order: 1, step: 0},
+	}
+	// Both parts fit in 11 tokens on their own but not together.
+	got, err := o.enforce(parts, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "a b c d f g h i j " {
+		t.Errorf("prompt = %q", got)
+	}
+	if n, _ := (superadditiveCounter{}).CountTokens(got); n > 11 {
+		t.Errorf("prompt has %d tokens, more than 11", n)
+	}
+}
+
+func TestEnforceDropsPartsBelowMinTokens(t *testing.T) {
+	o := newOptions([]Option{WithTokenCounter(wordCounter{})})
+	parts := []FunctionNodeOutput{
+		{node: &FunctionNode{ID: "a"}, output: "a b c d ", step: 0},
+		{node: &FunctionNode{ID: "b", MinTokens: 3}, output: "e f g h ", step: 1},
+	}
+	got, err := o.enforce(parts, 6)
+	if err != nil || got != "a b c d " {
+		t.Errorf("prompt = %q, %v, want the second part dropped", got, err)
+	}
+}
+
+func TestGeneratePromptSummarizesParts(t *testing.T) {
+	var gotLimit int
+	history := NewFunctionNode("history", 1, func(string, int) (string, error) {
+		return strings.Repeat("word ", 20), nil
+	})
+	history.MaxTokens = 5
+	history.Truncation = TruncateSummarize
+	root := NewFunctionNode("root", 0, nil, history)
+
+	report, err := GeneratePromptWithReport(root, "", 100, WithTokenCounter(wordCounter{}),
+		WithSummarizer(func(text string, maxTokens int) (string, error) {
+			gotLimit = maxTokens
+			return "short summary", nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Prompt != "short summary" || gotLimit != 5 {
+		t.Errorf("prompt = %q, summarizer limit = %d", report.Prompt, gotLimit)
+	}
+	if node := report.Nodes[1]; !node.Truncated || node.Generated != 20 || node.Tokens != 2 {
+		t.Errorf("report = %+v", node)
+	}
+}
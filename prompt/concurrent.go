@@ -0,0 +1,99 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeError records that the generator of a node failed.
+type NodeError struct {
+	ID  string
+	Err error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("prompt: node %q: %v", e.ID, e.Err)
+}
+
+func (e *NodeError) Unwrap() error {
+	return e.Err
+}
+
+// NodeErrors lists the failed nodes of a prompt tree in processing order.
+type NodeErrors []*NodeError
+
+func (e NodeErrors) Error() string {
+	parts := make([]string, len(e))
+	for i, err := range e {
+		parts[i] = err.Error()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Unwrap returns the errors of the nodes for errors.Is and errors.As.
+func (e NodeErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// WithConcurrency runs up to n generators at the same time. Generators must
+// then be safe for concurrent use. Each is passed the most tokens it could
+// get, which can be more than it gets when nodes are generated one at a
+// time, and the parts are fitted to their actual budget in processing order,
+// so the prompt does not depend on which generator finishes first. Parts and
+// errors of nodes that are skipped for lack of budget are discarded; the
+// other failing nodes are reported together as NodeErrors. Values below 2
+// generate one node at a time, which is the default.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// generation is the outcome of running the generator of a node.
+type generation struct {
+	part     string
+	err      error
+	duration time.Duration
+}
+
+// generateAll runs the generators of nodes concurrently. Each node is passed
+// the budget it would get if every node before it generated nothing, which
+// bounds what it can be given when the parts are fitted in order. Failures
+// are left in the results for the caller to report.
+func (o *options) generateAll(nodes []*FunctionNode, input string, maxLength, reserved int) []generation {
+	results := make([]generation, len(nodes))
+	sem := make(chan struct{}, o.concurrency)
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		if !node.generates() {
+			continue
+		}
+		reserved = maxInt(reserved-node.MinTokens, 0)
+		limit := node.budget(maxLength, reserved)
+		if limit <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, node *FunctionNode, limit int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = o.timedGenerate(node, input, limit)
+		}(i, node, limit)
+	}
+	wg.Wait()
+	return results
+}
+
+// timedGenerate runs the generator of node and measures how long it takes.
+func (o *options) timedGenerate(node *FunctionNode, input string, maxTokens int) generation {
+	start := time.Now()
+	part, err := o.generate(node, input, maxTokens)
+	return generation{part: part, err: err, duration: time.Since(start)}
+}
@@ -0,0 +1,148 @@
+package prompt
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sleepy returns s after a delay so concurrent generators finish out of order.
+func sleepy(s string, delay time.Duration) func(string, int) (string, error) {
+	return func(string, int) (string, error) {
+		time.Sleep(delay)
+		return s, nil
+	}
+}
+
+func TestConcurrentPromptMatchesSequential(t *testing.T) {
+	var children []*FunctionNode
+	for i := 0; i < 8; i++ {
+		words := fmt.Sprintf("part%d a b c d e\n", i)
+		node := NewFunctionNode(fmt.Sprintf("n%d", i), i%3, sleepy(words, time.Duration(8-i)*time.Millisecond))
+		node.Position = (i * 5) % 8
+		if i%2 == 0 {
+			node.Truncation = TruncateStart
+		}
+		children = append(children, node)
+	}
+	root := NewFunctionNode("root", 0, nil, children...)
+
+	sequential, err := GeneratePrompt(root, "", 30, WithTokenCounter(wordCounter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 10; run++ {
+		concurrent, err := GeneratePrompt(root, "", 30, WithTokenCounter(wordCounter{}), WithConcurrency(8))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if concurrent != sequential {
+			t.Fatalf("run %d: concurrent prompt\n%q\ndiffers from the sequential one\n%q", run, concurrent, sequential)
+		}
+	}
+}
+
+func TestConcurrentGeneratorsAreLimited(t *testing.T) {
+	var running, peak int32
+	generate := func(string, int) (string, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return "x ", nil
+	}
+	var children []*FunctionNode
+	for i := 0; i < 12; i++ {
+		children = append(children, NewFunctionNode(fmt.Sprint(i), i, generate))
+	}
+	if _, err := GeneratePrompt(NewFunctionNode("root", 0, nil, children...), "", 100, WithTokenCounter(wordCounter{}), WithConcurrency(3)); err != nil {
+		t.Fatal(err)
+	}
+	if peak > 3 || peak < 2 {
+		t.Errorf("peak concurrency = %d, want 2 or 3", peak)
+	}
+}
+
+func TestConcurrentNodeErrors(t *testing.T) {
+	errA, errC := errors.New("a failed"), errors.New("c failed")
+	fail := func(err error) func(string, int) (string, error) {
+		return func(string, int) (string, error) { return "", err }
+	}
+	root := NewFunctionNode("root", 0, nil,
+		NewFunctionNode("c", 3, fail(errC)),
+		NewFunctionNode("b", 2, part("fine ")),
+		NewFunctionNode("a", 1, fail(errA)),
+	)
+
+	_, err := GeneratePrompt(root, "", 100, WithTokenCounter(wordCounter{}), WithConcurrency(4))
+	var nodeErrs NodeErrors
+	if !errors.As(err, &nodeErrs) || len(nodeErrs) != 2 || nodeErrs[0].ID != "a" || nodeErrs[1].ID != "c" {
+		t.Fatalf("error = %v, want NodeErrors for a and c in processing order", err)
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errC) {
+		t.Errorf("error = %v does not wrap both causes", err)
+	}
+
+	// One at a time the first failure stops generation.
+	_, err = GeneratePrompt(root, "", 100, WithTokenCounter(wordCounter{}))
+	if !errors.As(err, &nodeErrs) || len(nodeErrs) != 1 || nodeErrs[0].ID != "a" {
+		t.Errorf("sequential error = %v, want the error of a", err)
+	}
+}
+
+func TestConcurrentSkippedNodesAreDiscarded(t *testing.T) {
+	var late int32
+	root := NewFunctionNode("root", 0, nil,
+		NewFunctionNode("full", 1, part("one two three four ")),
+		NewFunctionNode("failing", 2, func(string, int) (string, error) {
+			return "", errors.New("not needed")
+		}),
+		NewFunctionNode("late", 3, func(string, int) (string, error) {
+			atomic.AddInt32(&late, 1)
+			return "late part ", nil
+		}),
+	)
+	report, err := GeneratePromptWithReport(root, "", 4, WithTokenCounter(wordCounter{}), WithConcurrency(4))
+	if err != nil {
+		t.Fatalf("error of a skipped node failed the prompt: %v", err)
+	}
+	if report.Prompt != "one two three four " {
+		t.Errorf("prompt = %q", report.Prompt)
+	}
+	for _, node := range report.Nodes[2:] {
+		if !node.Skipped || node.Tokens != 0 || node.Generated != 0 {
+			t.Errorf("node %s = %+v, want it skipped", node.ID, node)
+		}
+	}
+	if late != 1 {
+		t.Errorf("late generator ran %d times, want once ahead of time", late)
+	}
+}
+
+func TestConcurrentGeneratorsGetAnUpperBound(t *testing.T) {
+	var got int32
+	root := NewFunctionNode("root", 0, nil,
+		NewFunctionNode("first", 1, part("one two three ")),
+		NewFunctionNode("second", 2, func(_ string, maxTokens int) (string, error) {
+			atomic.StoreInt32(&got, int32(maxTokens))
+			return "four five six seven eight ", nil
+		}),
+	)
+	prompt, err := GeneratePrompt(root, "", 6, WithTokenCounter(wordCounter{}), WithConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 6 {
+		t.Errorf("second generator got %d tokens, want the upper bound 6", got)
+	}
+	if prompt != "one two three four five six " {
+		t.Errorf("prompt = %q, want the second part cut to its actual budget", prompt)
+	}
+}
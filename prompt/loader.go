@@ -0,0 +1,158 @@
+package prompt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Generator produces the part of a node that refers to it by name, given the
+// user input and the tokens the part may use.
+type Generator func(input string, maxTokens int) (string, error)
+
+// TemplateData is passed to node templates.
+type TemplateData struct {
+	Input     string      // User input the prompt is generated for
+	MaxTokens int         // Tokens the part may use
+	Data      interface{} // Value set with WithTemplateData
+}
+
+// WithGenerators sets the generators that nodes refer to by name.
+func WithGenerators(generators map[string]Generator) Option {
+	return func(o *options) {
+		o.generators = generators
+	}
+}
+
+// WithTemplateData sets the value available to node templates as .Data.
+func WithTemplateData(data interface{}) Option {
+	return func(o *options) {
+		o.templateData = data
+	}
+}
+
+// NodeConfig describes a FunctionNode in a prompt tree file. A node takes its
+// part from at most one of Generator, Text and Template; a node with none of
+// them only groups its children.
+type NodeConfig struct {
+	ID         string       `yaml:"id" json:"id"`
+	Priority   int          `yaml:"priority" json:"priority"`
+	Position   int          `yaml:"position,omitempty" json:"position,omitempty"`
+	Generator  string       `yaml:"generator,omitempty" json:"generator,omitempty"`
+	Text       string       `yaml:"text,omitempty" json:"text,omitempty"`
+	Template   string       `yaml:"template,omitempty" json:"template,omitempty"`
+	MinTokens  int          `yaml:"min_tokens,omitempty" json:"min_tokens,omitempty"`
+	MaxTokens  int          `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"`
+	Truncation Truncation   `yaml:"truncation,omitempty" json:"truncation,omitempty"`
+	Children   []NodeConfig `yaml:"children,omitempty" json:"children,omitempty"`
+}
+
+// LoadTree reads a prompt tree from a YAML or JSON file.
+func LoadTree(path string) (*FunctionNode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	root, err := ParseTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return root, nil
+}
+
+// ParseTree builds a prompt tree from YAML or JSON. Unknown fields are
+// rejected so that typos do not silently change the prompt.
+func ParseTree(data []byte) (*FunctionNode, error) {
+	var config NodeConfig
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return nil, fmt.Errorf("prompt: parse tree: %w", err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&config); err != nil {
+			return nil, fmt.Errorf("prompt: parse tree: %w", err)
+		}
+	}
+	return config.Build()
+}
+
+// Build turns the configuration into a FunctionNode tree. Generator names
+// are resolved when the prompt is generated, see WithGenerators.
+func (c NodeConfig) Build() (*FunctionNode, error) {
+	sources := 0
+	for _, s := range []string{c.Generator, c.Text, c.Template} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("prompt: node %q sets more than one of generator, text and template", c.ID)
+	}
+	node := &FunctionNode{
+		ID:         c.ID,
+		Priority:   c.Priority,
+		Position:   c.Position,
+		Generator:  c.Generator,
+		MinTokens:  c.MinTokens,
+		MaxTokens:  c.MaxTokens,
+		Truncation: c.Truncation,
+	}
+	if c.Text != "" {
+		text := c.Text
+		node.GeneratePart = func(string, int) (string, error) {
+			return text, nil
+		}
+	}
+	if c.Template != "" {
+		tmpl, err := template.New(c.ID).Option("missingkey=error").Parse(c.Template)
+		if err != nil {
+			return nil, fmt.Errorf("prompt: node %q: %w", c.ID, err)
+		}
+		node.Template = tmpl
+	}
+	for _, childConfig := range c.Children {
+		child, err := childConfig.Build()
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, child)
+	}
+	return node, nil
+}
+
+// generates reports whether node produces a part of its own.
+func (node *FunctionNode) generates() bool {
+	return node.GeneratePart != nil || node.Generator != "" || node.Template != nil
+}
+
+// generate produces the part of node with its GeneratePart, named Generator
+// or Template.
+func (o *options) generate(node *FunctionNode, input string, maxTokens int) (string, error) {
+	switch {
+	case node.GeneratePart != nil:
+		return node.GeneratePart(input, maxTokens)
+	case node.Generator != "":
+		generator, ok := o.generators[node.Generator]
+		if !ok {
+			return "", fmt.Errorf("unknown generator %q", node.Generator)
+		}
+		return generator(input, maxTokens)
+	case node.Template != nil:
+		var b strings.Builder
+		data := TemplateData{Input: input, MaxTokens: maxTokens, Data: o.templateData}
+		if err := node.Template.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	return "", nil
+}
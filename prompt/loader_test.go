@@ -0,0 +1,129 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const yamlTree = `
+id: root
+children:
+  - id: greeting
+    priority: 2
+    position: 1
+    text: "Hello.\n"
+  - id: task
+    priority: 1
+    position: 2
+    template: "Task: {{.Input}} ({{.Data}})\n"
+  - id: notes
+    priority: 3
+    generator: notes
+    min_tokens: 5
+    max_tokens: 10
+    truncation: start
+`
+
+const jsonTree = `{
+  "id": "root",
+  "children": [
+    {"id": "greeting", "priority": 2, "position": 1, "text": "Hello.\n"},
+    {"id": "task", "priority": 1, "position": 2, "template": "Task: {{.Input}} ({{.Data}})\n"},
+    {"id": "notes", "priority": 3, "generator": "notes", "min_tokens": 5, "max_tokens": 10, "truncation": "start"}
+  ]
+}`
+
+func TestParseTree(t *testing.T) {
+	notes := func(input string, maxTokens int) (string, error) {
+		return "Notes on " + input + ".\n", nil
+	}
+	for name, data := range map[string]string{"yaml": yamlTree, "json": jsonTree} {
+		t.Run(name, func(t *testing.T) {
+			root, err := ParseTree([]byte(data))
+			if err != nil {
+				t.Fatalf("ParseTree: %v", err)
+			}
+			if len(root.Children) != 3 {
+				t.Fatalf("children = %d, want 3", len(root.Children))
+			}
+			n := root.Children[2]
+			if n.ID != "notes" || n.Priority != 3 || n.Generator != "notes" || n.MinTokens != 5 || n.MaxTokens != 10 || n.Truncation != TruncateStart {
+				t.Errorf("notes node = %+v", n)
+			}
+			got, err := GeneratePrompt(root, "digging", 100, WithTokenCounter(wordCounter{}),
+				WithGenerators(map[string]Generator{"notes": notes}), WithTemplateData("gophers"))
+			if err != nil {
+				t.Fatalf("GeneratePrompt: %v", err)
+			}
+			if want := "Notes on digging.\nHello.\nTask: digging (gophers)\n"; got != want {
+				t.Errorf("prompt = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseTreeErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown yaml field": "id: root\npriorty: 1\n",
+		"unknown json field": `{"id": "root", "priorty": 1}`,
+		"bad truncation":     "id: root\ntruncation: sideways\n",
+		"bad template":       "id: root\nchildren:\n  - id: broken\n    template: \"{{.Input\"\n",
+		"several sources":    "id: root\ntext: hi\ngenerator: system\n",
+		"malformed json":     `{"id": "root",`,
+	}
+	for name, data := range tests {
+		if _, err := ParseTree([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestGeneratePromptUnknownGenerator(t *testing.T) {
+	root, err := ParseTree([]byte("id: root\nchildren:\n  - id: memory\n    generator: ltm\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GeneratePrompt(root, "", 100, WithTokenCounter(wordCounter{}))
+	if err == nil || !strings.Contains(err.Error(), `unknown generator "ltm"`) || !strings.Contains(err.Error(), `"memory"`) {
+		t.Errorf("error = %v, want the unknown generator and its node", err)
+	}
+}
+
+func TestGeneratePromptMissingTemplateKey(t *testing.T) {
+	root, err := ParseTree([]byte("id: root\ntemplate: \"{{.Data.Missing}}\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{"Present": "yes"}
+	if _, err := GeneratePrompt(root, "", 100, WithTokenCounter(wordCounter{}), WithTemplateData(data)); err == nil {
+		t.Error("missing template key was rendered")
+	}
+}
+
+func TestLoadTree(t *testing.T) {
+	root, err := LoadTree(filepath.Join("..", "prompts", "default.yaml"))
+	if err != nil {
+		t.Fatalf("LoadTree: %v", err)
+	}
+	var ids []string
+	for _, node := range renderOrder(root) {
+		ids = append(ids, node.ID)
+	}
+	if got := strings.Join(ids, " "); got != "root system functions_optional functions memories ltm_optional ltm stm" {
+		t.Errorf("render order = %s", got)
+	}
+
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.yaml")
+	if err := os.WriteFile(broken, []byte("id: [root\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTree(broken); err == nil || !strings.Contains(err.Error(), broken) {
+		t.Errorf("error = %v, want it to name the file", err)
+	}
+	if _, err := LoadTree(filepath.Join(dir, "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("error = %v, want a not exist error", err)
+	}
+}
@@ -2,50 +2,21 @@ package prompt
 
 import (
 	"sort"
-	"strings"
-	"sync"
-
-	"github.com/sugarme/tokenizer"
-	"github.com/sugarme/tokenizer/pretrained"
-)
-
-var (
-	tk   *tokenizer.Tokenizer
-	once sync.Once
+	"text/template"
 )
 
-func getTokenizer() (*tokenizer.Tokenizer, error) {
-	var err error
-	once.Do(func() {
-		// Initialize tokenizer here
-		configFile, e := tokenizer.CachedPath("HuggingFaceH4/zephyr-7b-beta", "tokenizer.json")
-		if e != nil {
-			err = e
-			return
-		}
-		tk, e = pretrained.FromFile(configFile)
-		if e != nil {
-			err = e
-			return
-		}
-	})
-	return tk, err
-}
-
-func getLength(s string, tk *tokenizer.Tokenizer) int {
-	en, err := tk.EncodeSingle(s)
-	if err != nil {
-		return 0
-	}
-	return en.Len()
-}
-
 // FunctionNode represents a node in the tree
 type FunctionNode struct {
 	ID           string
-	Priority     int
+	Priority     int // Budget priority among siblings, lower is generated first and trimmed last
+	Position     int // Render position among siblings, ties keep declaration order
 	GeneratePart func(string, int) (string, error)
 	Children     []*FunctionNode
+	Generator    string             // Name of a generator set with WithGenerators, used when GeneratePart is nil
+	Template     *template.Template // Executed with TemplateData when GeneratePart and Generator are unset
+	MinTokens    int                // Tokens held back for this part from nodes generated before it, unless all reservations exceed the budget; it is dropped rather than cut below this
+	MaxTokens    int                // Upper bound on the tokens of this part, 0 for none
+	Truncation   Truncation         // How this part is cut when it is over budget
 }
 
 // NewFunctionNode creates a new FunctionNode
@@ -65,69 +36,225 @@ func (a ByPriority) Len() int           { return len(a) }
 func (a ByPriority) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByPriority) Less(i, j int) bool { return a[i].Priority < a[j].Priority }
 
+// ByPosition implements sort.Interface for []*FunctionNode based on the Position field
+type ByPosition []*FunctionNode
+
+func (a ByPosition) Len() int           { return len(a) }
+func (a ByPosition) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a ByPosition) Less(i, j int) bool { return a[i].Position < a[j].Position }
+
+// sortedChildren returns the children of node stably sorted by by.
+func sortedChildren(node *FunctionNode, by func([]*FunctionNode) sort.Interface) []*FunctionNode {
+	children := append([]*FunctionNode(nil), node.Children...)
+	sort.Stable(by(children))
+	return children
+}
+
+// processingOrder lists the nodes under root ordered by their priority path,
+// the priorities from the root down to the node compared lexicographically.
+// A parent comes before its children and siblings with equal priority keep
+// declaration order.
+func processingOrder(root *FunctionNode) []*FunctionNode {
+	nodes := []*FunctionNode{root}
+	for _, child := range sortedChildren(root, func(n []*FunctionNode) sort.Interface { return ByPriority(n) }) {
+		nodes = append(nodes, processingOrder(child)...)
+	}
+	return nodes
+}
+
+// renderOrder lists the nodes under root depth first with siblings ordered
+// by Position, so a node's part is always rendered inside its parent's span
+// and never moves relative to its siblings when other parts are trimmed.
+func renderOrder(root *FunctionNode) []*FunctionNode {
+	nodes := []*FunctionNode{root}
+	for _, child := range sortedChildren(root, func(n []*FunctionNode) sort.Interface { return ByPosition(n) }) {
+		nodes = append(nodes, renderOrder(child)...)
+	}
+	return nodes
+}
+
 // FunctionNodeOutput stores the node's output and the order for output assembly
 type FunctionNodeOutput struct {
 	node   *FunctionNode
 	output string
 	order  int // This defines the order in which the output should be assembled
+	step   int // Position in processing order, later steps are trimmed first
+}
+
+// Option configures GeneratePrompt.
+type Option func(*options)
+
+type options struct {
+	counter      TokenCounter
+	summarizer   Summarizer
+	generators   map[string]Generator
+	templateData interface{}
+	concurrency  int
+}
+
+// WithTokenCounter sets the counter used to measure generated parts.
+// DefaultTokenCounter is used if it is not set.
+func WithTokenCounter(counter TokenCounter) Option {
+	return func(o *options) {
+		o.counter = counter
+	}
 }
 
-// GeneratePrompt assembles the prompt with separate processing and output orders
-func GeneratePrompt(root *FunctionNode, input string, maxLength int) (string, error) {
-	tk, err := getTokenizer()
+// WithSummarizer sets the summarizer used by nodes with TruncateSummarize.
+func WithSummarizer(summarizer Summarizer) Option {
+	return func(o *options) {
+		o.summarizer = summarizer
+	}
+}
+
+// Counter returns the token counter configured by opts, DefaultTokenCounter
+// if none is.
+func Counter(opts ...Option) TokenCounter {
+	return newOptions(opts).counter
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.counter == nil {
+		o.counter = DefaultTokenCounter()
+	}
+	return o
+}
+
+// GeneratePrompt assembles the prompt with separate processing and output orders.
+// Nodes are generated in order of their priority path, see processingOrder,
+// and rendered depth first by Position, see renderOrder. Each generator is
+// passed the tokens it may use: what is left of maxLength minus the
+// MinTokens of nodes still to come, capped by the node's MaxTokens. Parts over
+// that budget are cut with the node's Truncation, and once the prompt is
+// assembled the last generated parts are cut or dropped until the whole
+// prompt fits in maxLength tokens.
+func GeneratePrompt(root *FunctionNode, input string, maxLength int, opts ...Option) (string, error) {
+	report, err := GeneratePromptWithReport(root, input, maxLength, opts...)
 	if err != nil {
 		return "", err
 	}
-	var queue []FunctionNodeOutput
+	return report.Prompt, nil
+}
+
+// GeneratePromptWithReport is like GeneratePrompt but also reports what each
+// node contributed.
+func GeneratePromptWithReport(root *FunctionNode, input string, maxLength int, opts ...Option) (*Report, error) {
+	o := newOptions(opts)
+	report := &Report{MaxLength: maxLength}
 	var outputParts []FunctionNodeOutput // This will store the outputs in the order they should be assembled
 
-	queue = append(queue, FunctionNodeOutput{node: root, output: "", order: root.Priority})
+	order := make(map[*FunctionNode]int)
+	for i, node := range renderOrder(root) {
+		order[node] = i
+	}
 
+	reserved := reservedTokens(root)
+	if reserved > maxLength {
+		// The reservations cannot all be met, so nodes are served in order.
+		reserved = 0
+	}
 	count := 0
 
-	for len(queue) > 0 {
-		if count >= maxLength {
-			break
-		}
-		current := queue[0]
-		queue = queue[1:]
-
-		// fmt.Printf("Processing Node: %s\n", current.node.ID)
-
-		var part string
-		var err error
+	nodes := processingOrder(root)
+	var generations []generation
+	if o.concurrency > 1 {
+		generations = o.generateAll(nodes, input, maxLength, reserved)
+	}
+	var errs NodeErrors
 
-		if current.node.GeneratePart != nil {
-			part, err = current.node.GeneratePart(input, maxLength-count)
-			if err != nil {
-				return "", err
+	for step, node := range nodes {
+		current := FunctionNodeOutput{node: node, order: order[node], step: step}
+		nodeReport := NodeReport{Node: node, ID: node.ID, Priority: node.Priority, Position: node.Position}
+		if node.generates() {
+			reserved = maxInt(reserved-node.MinTokens, 0)
+			nodeReport.Budget = node.budget(maxLength-count, reserved)
+			if nodeReport.Budget > 0 {
+				var result generation
+				if generations != nil {
+					result = generations[step]
+				} else {
+					result = o.timedGenerate(node, input, nodeReport.Budget)
+				}
+				nodeReport.Duration = result.duration
+				if result.err != nil {
+					errs = append(errs, &NodeError{ID: node.ID, Err: result.err})
+					if generations == nil {
+						return nil, errs
+					}
+					// Keep going to report every failing node at once.
+					continue
+				}
+				part := result.part
+				var err error
+				if nodeReport.Generated, err = o.counter.CountTokens(part); err != nil {
+					return nil, err
+				}
+				fitted, n, err := o.fit(node, part, nodeReport.Budget)
+				if err != nil {
+					return nil, err
+				}
+				nodeReport.Tokens = n
+				nodeReport.Truncated = fitted != part
+				nodeReport.Dropped = fitted == "" && part != ""
+				current.output = fitted
+				outputParts = append(outputParts, current)
+				count += n
+			} else {
+				nodeReport.Skipped = true
 			}
-			// fmt.Printf("Generated Part: %s\n", part)
-			current.output = part
-			outputParts = append(outputParts, current)
-			count += getLength(part, tk)
-		}
-
-		for _, child := range current.node.Children {
-			queue = append(queue, FunctionNodeOutput{node: child, output: "", order: child.Priority})
 		}
+		report.Nodes = append(report.Nodes, nodeReport)
+	}
+	if len(errs) > 0 {
+		return nil, errs
 	}
 
 	// Sort outputParts based on the order field
-	sort.Slice(outputParts, func(i, j int) bool {
+	sort.SliceStable(outputParts, func(i, j int) bool {
 		return outputParts[i].order < outputParts[j].order
 	})
 
-	// Assemble the final output based on the sorted parts
-	var finalOutput strings.Builder
+	prompt, err := o.enforce(outputParts, maxLength)
+	if err != nil {
+		return nil, err
+	}
+	// Record the parts that had to give way once the prompt was assembled.
 	for _, part := range outputParts {
-		if finalOutput.Len() > 0 && part.output != "" {
-			finalOutput.WriteString("")
+		nodeReport := &report.Nodes[part.step]
+		n, err := o.counter.CountTokens(part.output)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case part.output == "" && nodeReport.Tokens > 0:
+			nodeReport.Dropped = true
+			nodeReport.Tokens = 0
+		case n < nodeReport.Tokens:
+			nodeReport.Truncated = true
+			nodeReport.Tokens = n
 		}
-		finalOutput.WriteString(part.output)
 	}
+	report.Prompt = prompt
+	if report.Tokens, err = o.counter.CountTokens(prompt); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
 
-	return finalOutput.String(), nil
+// reservedTokens sums the MinTokens of the generating nodes under root.
+func reservedTokens(root *FunctionNode) int {
+	total := 0
+	if root.generates() {
+		total += root.MinTokens
+	}
+	for _, child := range root.Children {
+		total += reservedTokens(child)
+	}
+	return total
 }
 
 // func main() {
@@ -0,0 +1,70 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+)
+
+func part(s string) func(string, int) (string, error) {
+	return func(string, int) (string, error) { return s, nil }
+}
+
+func nodeIDs(nodes []*FunctionNode) string {
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestProcessingAndRenderOrder(t *testing.T) {
+	// Priority decides what is generated first, Position where it goes.
+	a := NewFunctionNode("a", 3, part("A"))
+	b := NewFunctionNode("b", 1, part("B"))
+	b.Position = 2
+	c := NewFunctionNode("c", 2, nil, NewFunctionNode("c1", 2, part("C1")), NewFunctionNode("c2", 1, part("C2")))
+	c.Position = 1
+	root := NewFunctionNode("root", 0, nil, a, b, c)
+
+	if got := nodeIDs(processingOrder(root)); got != "root b c c2 c1 a" {
+		t.Errorf("processing order = %s", got)
+	}
+	if got := nodeIDs(renderOrder(root)); got != "root a c c1 c2 b" {
+		t.Errorf("render order = %s", got)
+	}
+	prompt, err := GeneratePrompt(root, "", 100, WithTokenCounter(wordCounter{}))
+	if err != nil || prompt != "AC1C2B" {
+		t.Errorf("prompt = %q, %v", prompt, err)
+	}
+}
+
+func TestEqualPositionsKeepDeclarationOrder(t *testing.T) {
+	var children []*FunctionNode
+	var want strings.Builder
+	for i, id := range strings.Fields("n0 n1 n2 n3 n4 n5 n6 n7 n8 n9 n10 n11 n12 n13 n14 n15") {
+		// Reverse priorities so generation order differs from declaration order.
+		children = append(children, NewFunctionNode(id, 100-i, part(id+" ")))
+		want.WriteString(id + " ")
+	}
+	root := NewFunctionNode("root", 0, nil, children...)
+	for run := 0; run < 10; run++ {
+		prompt, err := GeneratePrompt(root, "", 100, WithTokenCounter(wordCounter{}), WithConcurrency(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prompt != want.String() {
+			t.Fatalf("prompt = %q, want %q", prompt, want.String())
+		}
+	}
+}
+
+func TestLowPriorityPartsGiveWayFirst(t *testing.T) {
+	first := NewFunctionNode("first", 2, part("one two three "))
+	second := NewFunctionNode("second", 1, part("four five six "))
+	second.Position = 1
+	root := NewFunctionNode("root", 0, nil, first, second)
+	prompt, err := GeneratePrompt(root, "", 4, WithTokenCounter(wordCounter{}))
+	if err != nil || prompt != "one four five six " {
+		t.Errorf("prompt = %q, %v, want the lower priority part cut", prompt, err)
+	}
+}
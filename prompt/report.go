@@ -0,0 +1,103 @@
+package prompt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report describes how a prompt was assembled.
+type Report struct {
+	Prompt    string
+	Tokens    int // Tokens in Prompt
+	MaxLength int
+	Nodes     []NodeReport // In processing order
+}
+
+// NodeReport describes what a node contributed to the prompt. Nodes without
+// a generator only group their children and report zero tokens.
+type NodeReport struct {
+	Node      *FunctionNode
+	ID        string
+	Priority  int
+	Position  int
+	Budget    int           // Tokens the node was allowed to use
+	Generated int           // Tokens the node generated
+	Tokens    int           // Tokens the node kept in the prompt
+	Truncated bool          // The part was cut to fit
+	Dropped   bool          // The part was generated but left out
+	Skipped   bool          // No budget was left, so the node was not generated
+	Duration  time.Duration // Time spent generating the part
+}
+
+// Status summarizes what happened to the node's part.
+func (r NodeReport) Status() string {
+	switch {
+	case r.Node != nil && !r.Node.generates():
+		return "group"
+	case r.Skipped:
+		return "skipped"
+	case r.Dropped:
+		return "dropped"
+	case r.Truncated:
+		return "truncated"
+	case r.Generated == 0:
+		return "empty"
+	}
+	return "ok"
+}
+
+const reportHeader = "NODE\tPRIORITY\tPOSITION\tSTATUS\tTOKENS\tGENERATED\tBUDGET\tTIME"
+
+func writeRow(w io.Writer, name string, r NodeReport) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%d\t%d\t%s\n",
+		name, r.Priority, r.Position, r.Status(), r.Tokens, r.Generated, r.Budget, r.Duration.Round(time.Microsecond))
+}
+
+// WriteTree renders the tree under root in render order with the report of
+// each node.
+func (r *Report) WriteTree(w io.Writer, root *FunctionNode) error {
+	byNode := make(map[*FunctionNode]NodeReport, len(r.Nodes))
+	for _, nodeReport := range r.Nodes {
+		byNode[nodeReport.Node] = nodeReport
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, reportHeader)
+	var walk func(node *FunctionNode, prefix, branch string)
+	walk = func(node *FunctionNode, prefix, branch string) {
+		writeRow(tw, prefix+branch+node.ID, byNode[node])
+		switch branch {
+		case "├── ":
+			prefix += "│   "
+		case "└── ":
+			prefix += "    "
+		}
+		children := sortedChildren(node, func(n []*FunctionNode) sort.Interface { return ByPosition(n) })
+		for i, child := range children {
+			if i == len(children)-1 {
+				walk(child, prefix, "└── ")
+			} else {
+				walk(child, prefix, "├── ")
+			}
+		}
+	}
+	walk(root, "", "")
+	fmt.Fprintf(tw, "total\t\t\t\t%d\t\t%d\t\n", r.Tokens, r.MaxLength)
+	return tw.Flush()
+}
+
+// String renders the per-node breakdown in processing order.
+func (r *Report) String() string {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, reportHeader)
+	for _, nodeReport := range r.Nodes {
+		writeRow(tw, nodeReport.ID, nodeReport)
+	}
+	fmt.Fprintf(tw, "total\t\t\t\t%d\t\t%d\t\n", r.Tokens, r.MaxLength)
+	tw.Flush()
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package prompt
+
+import (
+	"strings"
+	"testing"
+)
+
+// reportTree covers every node status within a 10 word budget.
+func reportTree() *FunctionNode {
+	system := NewFunctionNode("system", 1, part("You are helpful.\n"))
+	tools := NewFunctionNode("tools", 2, part("Search Browse Finish Lookup Calculate\n"))
+	tools.Position = 1
+	tools.MaxTokens = 2
+	tools.Truncation = TruncateDrop
+	history := NewFunctionNode("history", 1, part(strings.Repeat("older ", 13)+"newest words are kept here\n"))
+	history.MaxTokens = 7
+	history.Truncation = TruncateStart
+	memories := NewFunctionNode("memories", 3, nil, history)
+	memories.Position = 2
+	extra := NewFunctionNode("extra", 4, part("never generated\n"))
+	extra.Position = 3
+	empty := NewFunctionNode("empty", 0, part(""))
+	empty.Position = 4
+	return NewFunctionNode("root", 0, nil, system, tools, memories, extra, empty)
+}
+
+func TestGeneratePromptWithReport(t *testing.T) {
+	report, err := GeneratePromptWithReport(reportTree(), "", 10, WithTokenCounter(wordCounter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "You are helpful.\nolder older newest words are kept here\n"; report.Prompt != want {
+		t.Errorf("prompt = %q, want %q", report.Prompt, want)
+	}
+	if report.Tokens != 10 || report.MaxLength != 10 {
+		t.Errorf("tokens = %d of %d", report.Tokens, report.MaxLength)
+	}
+	want := []struct {
+		id                        string
+		status                    string
+		budget, generated, tokens int
+	}{
+		{"root", "group", 0, 0, 0},
+		{"empty", "empty", 10, 0, 0},
+		{"system", "ok", 10, 3, 3},
+		{"tools", "dropped", 2, 5, 0},
+		{"memories", "group", 0, 0, 0},
+		{"history", "truncated", 7, 18, 7},
+		{"extra", "skipped", 0, 0, 0},
+	}
+	if len(report.Nodes) != len(want) {
+		t.Fatalf("nodes = %d, want %d", len(report.Nodes), len(want))
+	}
+	for i, w := range want {
+		n := report.Nodes[i]
+		if n.ID != w.id || n.Status() != w.status || n.Budget != w.budget || n.Generated != w.generated || n.Tokens != w.tokens {
+			t.Errorf("node %d = %s %s budget %d generated %d tokens %d, want %+v",
+				i, n.ID, n.Status(), n.Budget, n.Generated, n.Tokens, w)
+		}
+	}
+}
+
+func TestReportRendering(t *testing.T) {
+	root := reportTree()
+	report, err := GeneratePromptWithReport(root, "", 10, WithTokenCounter(wordCounter{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range report.Nodes {
+		report.Nodes[i].Duration = 0
+	}
+
+	var tree strings.Builder
+	if err := report.WriteTree(&tree, root); err != nil {
+		t.Fatal(err)
+	}
+	wantTree := `NODE             PRIORITY  POSITION  STATUS     TOKENS  GENERATED  BUDGET  TIME
+root             0         0         group      0       0          0       0s
+├── system       1         0         ok         3       3          10      0s
+├── tools        2         1         dropped    0       5          2       0s
+├── memories     3         2         group      0       0          0       0s
+│   └── history  1         0         truncated  7       18         7       0s
+├── extra        4         3         skipped    0       0          0       0s
+└── empty        0         4         empty      0       0          10      0s
+total                                           10                 10      
+`
+	if tree.String() != wantTree {
+		t.Errorf("WriteTree =\n%s\nwant\n%s", tree.String(), wantTree)
+	}
+
+	wantList := `NODE      PRIORITY  POSITION  STATUS     TOKENS  GENERATED  BUDGET  TIME
+root      0         0         group      0       0          0       0s
+empty     0         4         empty      0       0          10      0s
+system    1         0         ok         3       3          10      0s
+tools     2         1         dropped    0       5          2       0s
+memories  3         2         group      0       0          0       0s
+history   1         0         truncated  7       18         7       0s
+extra     4         3         skipped    0       0          0       0s
+total                                    10                 10      
+`
+	if report.String() != wantList {
+		t.Errorf("String =\n%s\nwant\n%s", report.String(), wantList)
+	}
+}
@@ -0,0 +1,189 @@
+package prompt
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sugarme/tokenizer"
+	"github.com/sugarme/tokenizer/pretrained"
+)
+
+// DefaultTokenizerRepo is the Hugging Face repository whose tokenizer.json is
+// used when no TokenCounter is configured.
+const DefaultTokenizerRepo = "HuggingFaceH4/zephyr-7b-beta"
+
+// TokenCounter counts the tokens in a piece of text.
+type TokenCounter interface {
+	CountTokens(s string) (int, error)
+}
+
+// TokenCounterFunc adapts a function to the TokenCounter interface.
+type TokenCounterFunc func(s string) (int, error)
+
+// CountTokens calls f(s).
+func (f TokenCounterFunc) CountTokens(s string) (int, error) { return f(s) }
+
+// TokenizerCounter counts tokens with a Hugging Face tokenizer.
+type TokenizerCounter struct {
+	Tokenizer *tokenizer.Tokenizer
+}
+
+// CountTokens encodes s and returns the number of tokens. Encoding errors are
+// returned rather than counted as zero.
+func (c *TokenizerCounter) CountTokens(s string) (int, error) {
+	en, err := c.Tokenizer.EncodeSingle(s)
+	if err != nil {
+		return 0, fmt.Errorf("prompt: encode: %w", err)
+	}
+	return en.Len(), nil
+}
+
+// NewFileTokenCounter loads a tokenizer.json from a local path, which works
+// on machines without network access.
+func NewFileTokenCounter(path string) (*TokenizerCounter, error) {
+	tk, err := pretrained.FromFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("prompt: load tokenizer %s: %w", path, err)
+	}
+	return &TokenizerCounter{Tokenizer: tk}, nil
+}
+
+// NewRepoTokenCounter loads the tokenizer.json of a Hugging Face repository,
+// downloading it into the local cache on first use.
+func NewRepoTokenCounter(repo string) (*TokenizerCounter, error) {
+	path, err := tokenizer.CachedPath(repo, "tokenizer.json")
+	if err != nil {
+		return nil, fmt.Errorf("prompt: fetch tokenizer %s: %w", repo, err)
+	}
+	return NewFileTokenCounter(path)
+}
+
+// modelTokenizerRepos maps model families, as named by Ollama, to a public
+// repository with a matching tokenizer.
+var modelTokenizerRepos = []struct {
+	prefix string
+	repo   string
+}{
+	{"zephyr", "HuggingFaceH4/zephyr-7b-beta"},
+	{"neural-chat", "Intel/neural-chat-7b-v3-1"},
+	{"openhermes", "teknium/OpenHermes-2.5-Mistral-7B"},
+	{"mistral", "mistralai/Mistral-7B-v0.1"},
+	{"mixtral", "mistralai/Mixtral-8x7B-v0.1"},
+	{"llama2", "hf-internal-testing/llama-tokenizer"},
+	{"llama-2", "hf-internal-testing/llama-tokenizer"},
+	{"orca-mini", "hf-internal-testing/llama-tokenizer"},
+	{"vicuna", "hf-internal-testing/llama-tokenizer"},
+}
+
+// TokenizerRepoForModel returns the Hugging Face repository whose tokenizer
+// matches model. Tags such as ":7b" and namespaces such as "library/" are
+// ignored.
+func TokenizerRepoForModel(model string) (string, bool) {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	for _, m := range modelTokenizerRepos {
+		if strings.HasPrefix(name, m.prefix) {
+			return m.repo, true
+		}
+	}
+	return "", false
+}
+
+// NewModelTokenCounter loads the tokenizer that matches model.
+func NewModelTokenCounter(model string) (*TokenizerCounter, error) {
+	repo, ok := TokenizerRepoForModel(model)
+	if !ok {
+		return nil, fmt.Errorf("prompt: no known tokenizer for model %q", model)
+	}
+	return NewRepoTokenCounter(repo)
+}
+
+// HeuristicCounter estimates token counts without a tokenizer. It assumes
+// roughly four characters or three quarters of a word per token and takes
+// the larger of the two, so it tends to overestimate, which is the safe
+// direction for budgeting.
+type HeuristicCounter struct{}
+
+// CountTokens estimates the number of tokens in s.
+func (HeuristicCounter) CountTokens(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	byRunes := (utf8.RuneCountInString(s) + 3) / 4
+	byWords := (len(strings.Fields(s))*4 + 2) / 3
+	if byWords > byRunes {
+		return byWords, nil
+	}
+	return byRunes, nil
+}
+
+// FallbackCounter loads a counter on first use and uses Fallback when loading
+// fails, so prompt generation keeps working offline. Errors from the loaded
+// counter itself are returned, not hidden behind the fallback.
+type FallbackCounter struct {
+	Load     func() (TokenCounter, error)
+	Fallback TokenCounter
+
+	once    sync.Once
+	counter TokenCounter
+	err     error
+}
+
+// NewFallbackCounter returns a FallbackCounter for load and fallback.
+func NewFallbackCounter(load func() (TokenCounter, error), fallback TokenCounter) *FallbackCounter {
+	return &FallbackCounter{Load: load, Fallback: fallback}
+}
+
+// CountTokens counts tokens with the loaded counter, or with Fallback if it
+// could not be loaded.
+func (c *FallbackCounter) CountTokens(s string) (int, error) {
+	c.once.Do(func() {
+		c.counter, c.err = c.Load()
+		if c.err != nil && c.Fallback != nil {
+			log.Printf("Tokenizer unavailable, estimating tokens: %v", c.err)
+			c.counter, c.err = c.Fallback, nil
+		}
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.counter.CountTokens(s)
+}
+
+var modelCounters sync.Map // Model name to *FallbackCounter
+
+// ModelTokenCounter returns a counter using the tokenizer that matches model,
+// falling back to HeuristicCounter if it is unknown or cannot be loaded.
+// Counters are shared per model, so each tokenizer is loaded once.
+func ModelTokenCounter(model string) *FallbackCounter {
+	if counter, ok := modelCounters.Load(model); ok {
+		return counter.(*FallbackCounter)
+	}
+	counter, _ := modelCounters.LoadOrStore(model, NewFallbackCounter(func() (TokenCounter, error) {
+		return NewModelTokenCounter(model)
+	}, HeuristicCounter{}))
+	return counter.(*FallbackCounter)
+}
+
+var defaultCounter TokenCounter = NewFallbackCounter(func() (TokenCounter, error) {
+	return NewRepoTokenCounter(DefaultTokenizerRepo)
+}, HeuristicCounter{})
+
+// DefaultTokenCounter returns the counter used when none is configured: the
+// DefaultTokenizerRepo tokenizer, or HeuristicCounter if it cannot be loaded.
+func DefaultTokenCounter() TokenCounter {
+	return defaultCounter
+}
+
+// CountTokens returns the number of tokens in s using DefaultTokenCounter.
+func CountTokens(s string) (int, error) {
+	return defaultCounter.CountTokens(s)
+}
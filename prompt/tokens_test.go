@@ -0,0 +1,85 @@
+package prompt
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTokenizerRepoForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		repo  string
+	}{
+		{"zephyr", "HuggingFaceH4/zephyr-7b-beta"},
+		{"library/Mistral:7b-instruct", "mistralai/Mistral-7B-v0.1"},
+		{"llama2:13b", "hf-internal-testing/llama-tokenizer"},
+		{"orca-mini:3b", "hf-internal-testing/llama-tokenizer"},
+		{"unknown-model", ""},
+	}
+	for _, tt := range tests {
+		repo, ok := TokenizerRepoForModel(tt.model)
+		if repo != tt.repo || ok != (tt.repo != "") {
+			t.Errorf("TokenizerRepoForModel(%q) = %q, %v, want %q", tt.model, repo, ok, tt.repo)
+		}
+	}
+}
+
+func TestHeuristicCounter(t *testing.T) {
+	tests := map[string]int{
+		"":                     0,
+		"abcd":                 2, // a single word counts as one and a third tokens
+		"abcdefghijkl":         3,
+		"a b c d e f":          8, // six words outweigh eleven characters
+		"supercalifragilistic": 5,
+		"日本語のテキスト":             2,
+	}
+	for s, want := range tests {
+		if got, _ := (HeuristicCounter{}).CountTokens(s); got != want {
+			t.Errorf("CountTokens(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+// fixedCounter counts every string as n tokens.
+type fixedCounter int
+
+func (n fixedCounter) CountTokens(s string) (int, error) {
+	return int(n), nil
+}
+
+func TestFallbackCounter(t *testing.T) {
+	loads := 0
+	c := NewFallbackCounter(func() (TokenCounter, error) {
+		loads++
+		return nil, errors.New("offline")
+	}, fixedCounter(7))
+	for i := 0; i < 3; i++ {
+		if n, err := c.CountTokens("text"); err != nil || n != 7 {
+			t.Fatalf("CountTokens = %d, %v, want the fallback count", n, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+
+	loaded := NewFallbackCounter(func() (TokenCounter, error) { return fixedCounter(3), nil }, fixedCounter(7))
+	if n, _ := loaded.CountTokens("text"); n != 3 {
+		t.Errorf("CountTokens = %d, want the loaded count", n)
+	}
+
+	noFallback := NewFallbackCounter(func() (TokenCounter, error) { return nil, errors.New("offline") }, nil)
+	if _, err := noFallback.CountTokens("text"); err == nil {
+		t.Error("load error without a fallback was hidden")
+	}
+}
+
+func TestModelTokenCounter(t *testing.T) {
+	c := ModelTokenCounter("unknown-model")
+	if c != ModelTokenCounter("unknown-model") {
+		t.Error("counters are not shared per model")
+	}
+	want, _ := HeuristicCounter{}.CountTokens("an unknown model is estimated")
+	if got, err := c.CountTokens("an unknown model is estimated"); err != nil || got != want {
+		t.Errorf("CountTokens = %d, %v, want the heuristic count %d", got, err, want)
+	}
+}
@@ -0,0 +1,205 @@
+// Package renderer formats conversations with the chat templates models were
+// trained on, producing raw prompts for backends that take them verbatim.
+package renderer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bgokden/miniagent/messages"
+	"github.com/bgokden/miniagent/prompt"
+)
+
+// Role is the author of a message in a chat template.
+type Role string
+
+const (
+	System    Role = "system"
+	User      Role = "user"
+	Assistant Role = "assistant"
+)
+
+// Message is a single turn of a conversation.
+type Message struct {
+	Role    Role
+	Content string
+}
+
+// Template renders a conversation into a raw prompt.
+type Template interface {
+	// Name identifies the template, see Get.
+	Name() string
+	// Render formats msgs and opens the assistant's turn, so the model
+	// continues with its reply. The leading BOS token, such as <s>, is left
+	// out since backends add it when they tokenize the prompt.
+	Render(msgs []Message) string
+	// Stop returns the sequences that end the assistant's turn.
+	Stop() []string
+}
+
+// Conversation converts a system prompt, message history and user prompt
+// into template messages. Function results are attributed to the user with
+// the function name in brackets, since the templates have no tool role.
+func Conversation(system string, history []messages.Message, userPrompt string) []Message {
+	return ConversationWithToolRole(system, history, userPrompt, User)
+}
+
+// ConversationWithToolRole is like Conversation but attributes function
+// results to toolRole, for chat protocols that have a role for them.
+func ConversationWithToolRole(system string, history []messages.Message, userPrompt string, toolRole Role) []Message {
+	var result []Message
+	if system != "" {
+		result = append(result, Message{Role: System, Content: system})
+	}
+	for _, msg := range history {
+		switch msg.Type {
+		case messages.HumanMessage:
+			result = append(result, Message{Role: User, Content: msg.Content})
+		case messages.AIMessage:
+			result = append(result, Message{Role: Assistant, Content: msg.Content})
+		case messages.FunctionResult:
+			content := msg.Content
+			if msg.FunctionName != "" {
+				content = fmt.Sprintf("[%s] %s", msg.FunctionName, msg.Content)
+			}
+			result = append(result, Message{Role: toolRole, Content: content})
+		default:
+			result = append(result, Message{Role: User, Content: fmt.Sprintf("%s: %s", msg.Sender, msg.Content)})
+		}
+	}
+	if userPrompt != "" {
+		result = append(result, Message{Role: User, Content: userPrompt})
+	}
+	return result
+}
+
+// RenderTree generates the system prompt from a prompt tree and renders it
+// with history and input using t. Only the newest messages of history that
+// fit in maxLength next to the system prompt and the markup of t are
+// rendered. Input is left out
+// when it is the last human message of history and all of history fits, so
+// the task is not sent twice.
+func RenderTree(t Template, root *prompt.FunctionNode, input string, maxLength int, history []messages.Message, opts ...prompt.Option) (string, error) {
+	system, err := prompt.GeneratePrompt(root, input, maxLength, opts...)
+	if err != nil {
+		return "", err
+	}
+	counter := prompt.Counter(opts...)
+	used, err := counter.CountTokens(system)
+	if err != nil {
+		return "", err
+	}
+	fixed, perMessage, err := Markup(t, system, input, counter)
+	if err != nil {
+		return "", err
+	}
+	used += fixed
+	count := func(s string) (int, error) {
+		n, err := counter.CountTokens(s)
+		return n + perMessage, err
+	}
+	kept, err := messages.Recent(history, maxLength-used, count)
+	if err != nil {
+		return "", err
+	}
+	if len(kept) < len(history) || !holdsInput(history, input) {
+		n, err := counter.CountTokens(input)
+		if err != nil {
+			return "", err
+		}
+		if kept, err = messages.Recent(history, maxLength-used-n, count); err != nil {
+			return "", err
+		}
+	} else {
+		input = ""
+	}
+	return t.Render(Conversation(system, kept, input)), nil
+}
+
+// Markup estimates the tokens t adds to the contents of a conversation:
+// fixed around system, input and the opened assistant turn, and perMessage
+// for every further message, measured on a user and assistant exchange.
+func Markup(t Template, system, input string, counter prompt.TokenCounter) (fixed, perMessage int, err error) {
+	const sample = "x"
+	count := func(texts ...string) int {
+		total := 0
+		for _, text := range texts {
+			if err == nil {
+				var n int
+				n, err = counter.CountTokens(text)
+				total += n
+			}
+		}
+		return total
+	}
+	empty := count(t.Render(nil))
+	fixed = count(t.Render(Conversation(system, nil, input))) - count(system, input)
+	exchange := count(t.Render([]Message{{User, sample}, {Assistant, sample}})) - empty - count(sample, sample)
+	if err != nil {
+		return 0, 0, err
+	}
+	return maxInt(fixed, 0), maxInt((exchange+1)/2, 0), nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// holdsInput reports whether the last human message of history is input.
+func holdsInput(history []messages.Message, input string) bool {
+	last, ok := messages.LastHumanContent(history)
+	return ok && last == input
+}
+
+var templates = []Template{ChatML, Zephyr, Llama2, Llama3, Mistral, Alpaca}
+
+// Get returns the template with the given name: chatml, zephyr, llama2,
+// llama3, mistral or alpaca.
+func Get(name string) (Template, bool) {
+	for _, t := range templates {
+		if t.Name() == strings.ToLower(name) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// modelTemplates maps model families, as named by Ollama, to their template.
+var modelTemplates = []struct {
+	prefix   string
+	template Template
+}{
+	{"zephyr", Zephyr},
+	{"llama3", Llama3},
+	{"llama-3", Llama3},
+	{"llama2", Llama2},
+	{"llama-2", Llama2},
+	{"codellama", Llama2},
+	{"mistral", Mistral},
+	{"mixtral", Mistral},
+	{"openhermes", ChatML},
+	{"dolphin", ChatML},
+	{"qwen", ChatML},
+	{"alpaca", Alpaca},
+}
+
+// ForModel returns the template matching model. Tags such as ":7b" and
+// namespaces such as "library/" are ignored.
+func ForModel(model string) (Template, bool) {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	for _, m := range modelTemplates {
+		if strings.HasPrefix(name, m.prefix) {
+			return m.template, true
+		}
+	}
+	return nil, false
+}
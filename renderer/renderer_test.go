@@ -0,0 +1,218 @@
+package renderer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bgokden/miniagent/messages"
+	"github.com/bgokden/miniagent/prompt"
+)
+
+var (
+	// chat has a system prompt and two user turns in a row.
+	chat = []Message{
+		{System, "Be brief."},
+		{User, "Hi"},
+		{Assistant, "Hello!"},
+		{User, "[Lookup] Gophers dig."},
+		{User, "And moles?"},
+	}
+	// assistantFirst opens with the assistant and has no system prompt.
+	assistantFirst = []Message{
+		{Assistant, "How can I help?"},
+		{User, "Tell me about gophers"},
+	}
+	// systemThenAssistant opens with the assistant after a system prompt.
+	systemThenAssistant = []Message{
+		{System, "Be brief."},
+		{Assistant, "How can I help?"},
+		{User, "Gophers?"},
+	}
+)
+
+func TestTemplates(t *testing.T) {
+	tests := []struct {
+		template Template
+		msgs     []Message
+		want     string
+	}{
+		{ChatML, chat, "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\nHello!<|im_end|>\n" +
+			"<|im_start|>user\n[Lookup] Gophers dig.<|im_end|>\n<|im_start|>user\nAnd moles?<|im_end|>\n<|im_start|>assistant\n"},
+		{ChatML, assistantFirst, "<|im_start|>assistant\nHow can I help?<|im_end|>\n<|im_start|>user\nTell me about gophers<|im_end|>\n<|im_start|>assistant\n"},
+
+		{Zephyr, chat, "<|system|>\nBe brief.</s>\n<|user|>\nHi</s>\n<|assistant|>\nHello!</s>\n" +
+			"<|user|>\n[Lookup] Gophers dig.</s>\n<|user|>\nAnd moles?</s>\n<|assistant|>\n"},
+		{Zephyr, assistantFirst, "<|assistant|>\nHow can I help?</s>\n<|user|>\nTell me about gophers</s>\n<|assistant|>\n"},
+
+		{Llama3, chat, "<|start_header_id|>system<|end_header_id|>\n\nBe brief.<|eot_id|>" +
+			"<|start_header_id|>user<|end_header_id|>\n\nHi<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\nHello!<|eot_id|>" +
+			"<|start_header_id|>user<|end_header_id|>\n\n[Lookup] Gophers dig.<|eot_id|><|start_header_id|>user<|end_header_id|>\n\nAnd moles?<|eot_id|>" +
+			"<|start_header_id|>assistant<|end_header_id|>\n\n"},
+		{Llama3, assistantFirst, "<|start_header_id|>assistant<|end_header_id|>\n\nHow can I help?<|eot_id|>" +
+			"<|start_header_id|>user<|end_header_id|>\n\nTell me about gophers<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n"},
+
+		// Llama 2 and Mistral need alternating turns, so consecutive user
+		// turns are merged and an assistant opening gets an empty instruction.
+		{Llama2, chat, "[INST] <<SYS>>\nBe brief.\n<</SYS>>\n\nHi [/INST] Hello! </s><s>[INST] [Lookup] Gophers dig.\n\nAnd moles? [/INST]"},
+		{Llama2, assistantFirst, "[INST] [/INST] How can I help? </s><s>[INST] Tell me about gophers [/INST]"},
+		{Llama2, systemThenAssistant, "[INST] <<SYS>>\nBe brief.\n<</SYS>> [/INST] How can I help? </s><s>[INST] Gophers? [/INST]"},
+
+		{Mistral, chat, "[INST] Be brief.\n\nHi [/INST]Hello!</s>[INST] [Lookup] Gophers dig.\n\nAnd moles? [/INST]"},
+		{Mistral, assistantFirst, "[INST] [/INST]How can I help?</s>[INST] Tell me about gophers [/INST]"},
+		{Mistral, systemThenAssistant, "[INST] Be brief. [/INST]How can I help?</s>[INST] Gophers? [/INST]"},
+
+		{Alpaca, chat, "Be brief.\n\n### Instruction:\nHi\n\n### Response:\nHello!\n\n### Instruction:\n[Lookup] Gophers dig.\n\nAnd moles?\n\n### Response:\n"},
+		{Alpaca, assistantFirst, alpacaPreamble + "\n\n### Response:\nHow can I help?\n\n### Instruction:\nTell me about gophers\n\n### Response:\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.template.Render(tt.msgs); got != tt.want {
+			t.Errorf("%s renders\n%q\nwant\n%q", tt.template.Name(), got, tt.want)
+		}
+	}
+}
+
+func TestStopIsACopy(t *testing.T) {
+	stop := ChatML.Stop()
+	stop[0] = "changed"
+	if ChatML.Stop()[0] != "<|im_end|>" {
+		t.Error("Stop exposes the template's stop sequences")
+	}
+}
+
+func TestGetAndForModel(t *testing.T) {
+	for _, name := range []string{"chatml", "zephyr", "llama2", "llama3", "mistral", "alpaca", "ChatML"} {
+		if tmpl, ok := Get(name); !ok || tmpl.Name() != strings.ToLower(name) {
+			t.Errorf("Get(%q) = %v, %v", name, tmpl, ok)
+		}
+	}
+	if _, ok := Get("vicuna"); ok {
+		t.Error("Get found an unknown template")
+	}
+
+	models := map[string]Template{
+		"zephyr":                 Zephyr,
+		"library/llama3:8b":      Llama3,
+		"Llama-2-7b-chat":        Llama2,
+		"codellama:13b":          Llama2,
+		"mixtral:8x7b":           Mistral,
+		"openhermes2.5-mistral":  ChatML,
+		"qwen:0.5b":              ChatML,
+		"someone/alpaca-7b:q4_0": Alpaca,
+	}
+	for model, want := range models {
+		if got, ok := ForModel(model); !ok || got != want {
+			t.Errorf("ForModel(%q) = %v, want %s", model, got, want.Name())
+		}
+	}
+	if _, ok := ForModel("phi"); ok {
+		t.Error("ForModel matched an unknown model")
+	}
+}
+
+func TestConversation(t *testing.T) {
+	history := []messages.Message{
+		{Type: messages.HumanMessage, Sender: "Human", Content: "Find gophers"},
+		{Type: messages.AIMessage, Sender: "AI", Content: "Searching"},
+		{Type: messages.FunctionResult, Sender: "System", FunctionName: "Search", Content: "Gophers dig."},
+		{Type: messages.FunctionResult, Sender: "System", Content: "Error parsing output."},
+		{Type: messages.ChatMessage, Sender: "Alice", Content: "Hello"},
+	}
+	want := []Message{
+		{System, "Be brief."},
+		{User, "Find gophers"},
+		{Assistant, "Searching"},
+		{User, "[Search] Gophers dig."},
+		{User, "Error parsing output."},
+		{User, "Alice: Hello"},
+		{User, "Continue"},
+	}
+	if got := Conversation("Be brief.", history, "Continue"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Conversation = %+v", got)
+	}
+	got := ConversationWithToolRole("", history[2:3], "", "tool")
+	if len(got) != 1 || got[0] != (Message{"tool", "[Search] Gophers dig."}) {
+		t.Errorf("ConversationWithToolRole = %+v", got)
+	}
+}
+
+// wordCounter counts whitespace separated words.
+type wordCounter struct{}
+
+func (wordCounter) CountTokens(s string) (int, error) {
+	return len(strings.Fields(s)), nil
+}
+
+func TestRenderTree(t *testing.T) {
+	root := prompt.NewFunctionNode("root", 0, func(string, int) (string, error) {
+		return "Be brief.", nil
+	})
+	counter := prompt.WithTokenCounter(wordCounter{})
+	history := []messages.Message{
+		{Type: messages.HumanMessage, Sender: "Human", Content: "Find gophers"},
+		{Type: messages.FunctionResult, Sender: "System", FunctionName: "Search", Content: "Gophers dig tunnels."},
+	}
+
+	got, err := RenderTree(ChatML, root, "Find gophers", 100, history, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(got, "Find gophers") != 1 {
+		t.Errorf("the task is not rendered once:\n%s", got)
+	}
+
+	// With room for the newest message only, the task is rendered from input.
+	got, err = RenderTree(ChatML, root, "Find gophers", 9, history, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\n[Search] Gophers dig tunnels.<|im_end|>\n" +
+		"<|im_start|>user\nFind gophers<|im_end|>\n<|im_start|>assistant\n"
+	if got != want {
+		t.Errorf("RenderTree =\n%q\nwant\n%q", got, want)
+	}
+
+	// A new input is rendered after the history.
+	got, err = RenderTree(ChatML, root, "And moles?", 100, history, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(got, "<|im_start|>user\nAnd moles?<|im_end|>\n<|im_start|>assistant\n") || !strings.Contains(got, "Find gophers") {
+		t.Errorf("RenderTree =\n%s", got)
+	}
+}
+
+func TestRenderTreeCountsTheMarkup(t *testing.T) {
+	root := prompt.NewFunctionNode("root", 0, func(string, int) (string, error) {
+		return "Be brief.", nil
+	})
+	var history []messages.Message
+	for i := 0; i < 30; i++ {
+		history = append(history, messages.Message{Type: messages.FunctionResult, Sender: "System", FunctionName: "Search", Content: "Gophers dig tunnels."})
+	}
+	counter := prompt.HeuristicCounter{}
+	for _, tmpl := range templates {
+		got, err := RenderTree(tmpl, root, "Find gophers", 100, history, prompt.WithTokenCounter(counter))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := counter.CountTokens(got); n > 100 {
+			t.Errorf("%s renders %d tokens, more than 100:\n%s", tmpl.Name(), n, got)
+		}
+		if !strings.Contains(got, "Gophers dig tunnels.") {
+			t.Errorf("%s renders no history:\n%s", tmpl.Name(), got)
+		}
+	}
+}
+
+func TestMarkup(t *testing.T) {
+	fixed, perMessage, err := Markup(ChatML, "Be brief.", "Find gophers", wordCounter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "<|im_start|>system", "<|im_start|>user" and "<|im_start|>assistant"
+	// are words of their own; the end markers stick to the content.
+	if fixed != 3 || perMessage != 1 {
+		t.Errorf("Markup = %d, %d, want 3 and 1", fixed, perMessage)
+	}
+}
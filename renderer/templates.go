@@ -0,0 +1,175 @@
+package renderer
+
+import "strings"
+
+// template implements Template with a render function.
+type template struct {
+	name   string
+	stop   []string
+	render func(b *strings.Builder, msgs []Message)
+}
+
+func (t *template) Name() string   { return t.name }
+func (t *template) Stop() []string { return append([]string(nil), t.stop...) }
+
+func (t *template) Render(msgs []Message) string {
+	var b strings.Builder
+	t.render(&b, msgs)
+	return b.String()
+}
+
+// ChatML is the <|im_start|> format used by OpenHermes, Dolphin and Qwen.
+var ChatML Template = &template{
+	name: "chatml",
+	stop: []string{"<|im_end|>"},
+	render: func(b *strings.Builder, msgs []Message) {
+		for _, msg := range msgs {
+			b.WriteString("<|im_start|>" + string(msg.Role) + "\n" + msg.Content + "<|im_end|>\n")
+		}
+		b.WriteString("<|im_start|>assistant\n")
+	},
+}
+
+// Zephyr is the <|system|> format of Zephyr models.
+var Zephyr Template = &template{
+	name: "zephyr",
+	stop: []string{"</s>", "<|user|>"},
+	render: func(b *strings.Builder, msgs []Message) {
+		for _, msg := range msgs {
+			b.WriteString("<|" + string(msg.Role) + "|>\n" + msg.Content + "</s>\n")
+		}
+		b.WriteString("<|assistant|>\n")
+	},
+}
+
+// Llama3 is the header format of Llama 3 instruct models.
+var Llama3 Template = &template{
+	name: "llama3",
+	stop: []string{"<|eot_id|>", "<|end_of_text|>"},
+	render: func(b *strings.Builder, msgs []Message) {
+		for _, msg := range msgs {
+			b.WriteString("<|start_header_id|>" + string(msg.Role) + "<|end_header_id|>\n\n" + msg.Content + "<|eot_id|>")
+		}
+		b.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	},
+}
+
+// Llama2 is the [INST] format of Llama 2 chat models, with the system prompt
+// in a <<SYS>> block inside the first instruction.
+var Llama2 Template = &template{
+	name: "llama2",
+	stop: []string{"</s>", "[INST]"},
+	render: func(b *strings.Builder, msgs []Message) {
+		system, turns := splitSystem(msgs)
+		if system != "" {
+			system = "<<SYS>>\n" + system + "\n<</SYS>>\n\n"
+		}
+		// Every exchange opens with BOS but the first, see Template.Render.
+		bos := func() {
+			if b.Len() > 0 {
+				b.WriteString("<s>")
+			}
+		}
+		open := false
+		for _, msg := range turns {
+			if msg.Role == User {
+				bos()
+				b.WriteString(instruction(system + msg.Content))
+				system, open = "", true
+				continue
+			}
+			if !open {
+				bos()
+				b.WriteString(instruction(strings.TrimSuffix(system, "\n\n")))
+				system = ""
+			}
+			b.WriteString(" " + msg.Content + " </s>")
+			open = false
+		}
+		if !open {
+			bos()
+			b.WriteString(instruction(strings.TrimSuffix(system, "\n\n")))
+		}
+	},
+}
+
+// Mistral is the [INST] format of Mistral instruct models, which have no
+// system role, so the system prompt opens the first instruction.
+var Mistral Template = &template{
+	name: "mistral",
+	stop: []string{"</s>", "[INST]"},
+	render: func(b *strings.Builder, msgs []Message) {
+		system, turns := splitSystem(msgs)
+		if system != "" {
+			system += "\n\n"
+		}
+		open := false
+		for _, msg := range turns {
+			if msg.Role == User {
+				b.WriteString(instruction(system + msg.Content))
+				system, open = "", true
+				continue
+			}
+			if !open {
+				b.WriteString(instruction(strings.TrimSuffix(system, "\n\n")))
+				system = ""
+			}
+			b.WriteString(msg.Content + "</s>")
+			open = false
+		}
+		if !open {
+			b.WriteString(instruction(strings.TrimSuffix(system, "\n\n")))
+		}
+	},
+}
+
+// instruction wraps content in the [INST] tags of Llama 2 and Mistral. An
+// empty instruction lets the conversation open with the assistant.
+func instruction(content string) string {
+	if content == "" {
+		return "[INST] [/INST]"
+	}
+	return "[INST] " + content + " [/INST]"
+}
+
+// alpacaPreamble is used by Alpaca when there is no system prompt.
+const alpacaPreamble = "Below is an instruction that describes a task. Write a response that appropriately completes the request."
+
+// Alpaca is the ### Instruction / ### Response format of Alpaca style models.
+var Alpaca Template = &template{
+	name: "alpaca",
+	stop: []string{"### Instruction:"},
+	render: func(b *strings.Builder, msgs []Message) {
+		system, turns := splitSystem(msgs)
+		if system == "" {
+			system = alpacaPreamble
+		}
+		b.WriteString(system + "\n\n")
+		for _, msg := range turns {
+			if msg.Role == User {
+				b.WriteString("### Instruction:\n" + msg.Content + "\n\n")
+			} else {
+				b.WriteString("### Response:\n" + msg.Content + "\n\n")
+			}
+		}
+		b.WriteString("### Response:\n")
+	},
+}
+
+// splitSystem joins the system messages of msgs and merges consecutive
+// messages of the same role, for formats that require alternating turns.
+func splitSystem(msgs []Message) (string, []Message) {
+	var system []string
+	var turns []Message
+	for _, msg := range msgs {
+		switch {
+		case msg.Role == System:
+			system = append(system, msg.Content)
+		case len(turns) > 0 && turns[len(turns)-1].Role == msg.Role:
+			turns[len(turns)-1].Content += "\n\n" + msg.Content
+		default:
+			turns = append(turns, msg)
+		}
+	}
+	return strings.Join(system, "\n\n"), turns
+}